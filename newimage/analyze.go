@@ -0,0 +1,137 @@
+package main
+
+import (
+	"log"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+var (
+	analyzeWorkerTotal = runtime.NumCPU() // Number of concurrent files being optimized and having their metadata read
+)
+
+var (
+	analyzeWorkerWaitGroup sync.WaitGroup
+)
+
+// analyzedFile is a file with its metadata read, ready to be renamed.
+type analyzedFile struct {
+	Index  int // position of the file in the walk
+	Path   string
+	Info   imageInfo
+	Paired []string // files renamed together with it
+	Skip   bool     // only optimized, or unchanged since recorded in the catalog
+	Err    error
+}
+
+type indexedPath struct {
+	Index int
+	Path  string
+}
+
+// startAnalyzeWorkers optimizes, if settings is not nil, and reads the
+// metadata of the files from paths. The analyzed files are produced in the
+// order of paths, so renames are deterministic.
+func startAnalyzeWorkers(done <-chan struct{}, paths <-chan string, settings *optimizeSettings) <-chan analyzedFile {
+	indexed := make(chan indexedPath)
+	go func() {
+		defer close(indexed)
+		index := 0
+		for path := range paths {
+			select {
+			case indexed <- indexedPath{Index: index, Path: path}:
+				index++
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	analyzed := make(chan analyzedFile)
+	analyzeWorkerWaitGroup.Add(analyzeWorkerTotal)
+	for i := 0; i < analyzeWorkerTotal; i++ {
+		go analyzeWorker(done, indexed, analyzed, settings)
+	}
+	go func() {
+		analyzeWorkerWaitGroup.Wait()
+		close(analyzed)
+	}()
+
+	return inWalkOrder(done, analyzed)
+}
+
+func analyzeWorker(done <-chan struct{}, paths <-chan indexedPath, analyzed chan<- analyzedFile, settings *optimizeSettings) {
+	defer analyzeWorkerWaitGroup.Done()
+
+	for path := range paths {
+		select {
+		case analyzed <- analyzeFile(path, settings):
+		case <-done:
+			return
+		}
+	}
+}
+
+func analyzeFile(path indexedPath, settings *optimizeSettings) analyzedFile {
+	file := analyzedFile{Index: path.Index, Path: path.Path}
+
+	if catalog != nil && catalog.unchanged(file.Path) {
+		file.Skip = true
+		return file
+	}
+
+	// the metadata is read before optimizing, which may strip it
+	if filetypesSupported[strings.ToLower(filepath.Ext(file.Path))] {
+		// raw files and their jpgs keep a shared name
+		file.Paired, file.Err = pairedFiles(file.Path)
+		if file.Err == nil {
+			file.Info, file.Err = readImageInfo(file.Path)
+		}
+	}
+
+	if settings != nil && optimizableFiletypes[strings.ToLower(filepath.Ext(file.Path))] {
+		newPath, err := optimizeImage(file.Path, *settings)
+		if err != nil {
+			log.Println(err)
+			newPath = file.Path
+		}
+		file.Path = newPath
+	}
+	if !filetypesSupported[strings.ToLower(filepath.Ext(file.Path))] {
+		// only optimized
+		file.Skip = true
+		file.Err = nil
+	}
+	return file
+}
+
+// inWalkOrder reorders the analyzed files by their Index.
+func inWalkOrder(done <-chan struct{}, analyzed <-chan analyzedFile) <-chan analyzedFile {
+	ordered := make(chan analyzedFile)
+
+	go func() {
+		defer close(ordered)
+		pending := make(map[int]analyzedFile)
+		next := 0
+		for file := range analyzed {
+			pending[file.Index] = file
+			for {
+				file, ok := pending[next]
+				if !ok {
+					break
+				}
+				delete(pending, next)
+				next++
+
+				select {
+				case ordered <- file:
+				case <-done:
+					return
+				}
+			}
+		}
+	}()
+	return ordered
+}
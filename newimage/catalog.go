@@ -0,0 +1,412 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"image"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+var (
+	catalog *photoCatalog // nil when no catalog is used
+)
+
+// catalogEntry is what the catalog knows of a file.
+type catalogEntry struct {
+	Path       string    `json:"path"`
+	Size       int64     `json:"size"`
+	ModTime    time.Time `json:"mod_time"`
+	Hash       string    `json:"sha256"`
+	Width      int       `json:"width,omitempty"`
+	Height     int       `json:"height,omitempty"`
+	Make       string    `json:"make,omitempty"`
+	Model      string    `json:"model,omitempty"`
+	Lens       string    `json:"lens,omitempty"`
+	Date       time.Time `json:"date"`
+	DateSource string    `json:"date_source"`
+	Lat        *float64  `json:"latitude,omitempty"`
+	Long       *float64  `json:"longitude,omitempty"`
+	City       string    `json:"city,omitempty"`
+	Country    string    `json:"country,omitempty"`
+	Naming     string    `json:"naming,omitempty"`    // namingFingerprint of the run that named it
+	CopiedTo   string    `json:"copied_to,omitempty"` // set on sources copied with --dest --copy, which only have Size, ModTime and Naming
+}
+
+// photoCatalog is a file store of the metadata of processed files, with one
+// JSON entry per line.
+type photoCatalog struct {
+	file    string
+	naming  string // namingFingerprint of this run
+	mutex   sync.Mutex
+	entries map[string]catalogEntry // by absolute path
+}
+
+// loadCatalog reads the catalog in file, which may not exist yet.
+func loadCatalog(file string) (*photoCatalog, error) {
+	c := &photoCatalog{file: file, entries: make(map[string]catalogEntry)}
+
+	f, err := os.Open(file)
+	if os.IsNotExist(err) {
+		return c, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(scanner.Bytes()) == 0 {
+			continue
+		}
+		var entry catalogEntry
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("%v:%v: %v", file, line, err)
+		}
+		c.entries[entry.Path] = entry
+	}
+	return c, scanner.Err()
+}
+
+// namingFingerprint returns a hash of the settings that decide the names of
+// the files, so files named with other settings are not skipped as unchanged.
+func namingFingerprint(citiesFile string, countriesFile string) string {
+	var zone string
+	if timezone != nil {
+		zone = timezone.String()
+	}
+	settings := []string{
+		renameTemplate,
+		sortDest,
+		sortLayout,
+		fmt.Sprint(sortCopy),
+		eventName,
+		zone,
+		cameraOffsets.String(),
+		dirOffsets.String(),
+		strings.Join(dateSources, ","),
+		fmt.Sprint(writeDates),
+		citiesFile,
+		countriesFile,
+	}
+	hash := sha256.Sum256([]byte(strings.Join(settings, "\x00")))
+	return hex.EncodeToString(hash[:8])
+}
+
+// unchanged returns true if path is in the catalog with the same size and
+// ModTime, and was named with the same settings, so it was already processed.
+// Sources copied with --dest --copy are unchanged while their copy exists.
+func (c *photoCatalog) unchanged(path string) bool {
+	c.mutex.Lock()
+	entry, ok := c.entries[path]
+	c.mutex.Unlock()
+	if !ok {
+		return false
+	}
+	fstat, err := os.Stat(path)
+	if err != nil {
+		return false
+	}
+	if entry.CopiedTo != "" {
+		if _, err := os.Stat(entry.CopiedTo); err != nil {
+			// copied again when the copy is gone
+			return false
+		}
+	}
+	return fstat.Size() == entry.Size && fstat.ModTime().Equal(entry.ModTime) && entry.Naming == c.naming
+}
+
+// record adds path, whose metadata is info, to the catalog. oldPath is
+// removed from it when path was renamed from oldPath.
+func (c *photoCatalog) record(oldPath string, path string, info imageInfo) error {
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return err
+	}
+	fstat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	hash, err := fileHash(path)
+	if err != nil {
+		return err
+	}
+
+	entry := catalogEntry{
+		Path:       path,
+		Size:       fstat.Size(),
+		ModTime:    fstat.ModTime(),
+		Hash:       hex.EncodeToString(hash),
+		Make:       info.Make,
+		Model:      info.Model,
+		Lens:       info.Lens,
+		Date:       info.Time,
+		DateSource: info.DateSource,
+		City:       info.City,
+		Country:    info.Country,
+		Naming:     c.naming,
+	}
+	entry.Width, entry.Height = imageDimensions(path)
+	if info.HasLocation {
+		lat, long := info.Lat, info.Long
+		entry.Lat, entry.Long = &lat, &long
+	}
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+	if oldPath != path && sortDest != "" && sortCopy {
+		// the source stays, recorded so the next run skips it as unchanged
+		source, err := c.sourceEntry(oldPath, path)
+		if err != nil {
+			return err
+		}
+		c.entries[source.Path] = source
+	} else if oldPath != path {
+		delete(c.entries, oldPath)
+	}
+	c.entries[path] = entry
+	return nil
+}
+
+// sourceEntry returns the entry of oldPath, copied to path.
+func (c *photoCatalog) sourceEntry(oldPath string, path string) (catalogEntry, error) {
+	oldPath, err := filepath.Abs(oldPath)
+	if err != nil {
+		return catalogEntry{}, err
+	}
+	fstat, err := os.Stat(oldPath)
+	if err != nil {
+		return catalogEntry{}, err
+	}
+	return catalogEntry{
+		Path:     oldPath,
+		Size:     fstat.Size(),
+		ModTime:  fstat.ModTime(),
+		Naming:   c.naming,
+		CopiedTo: path,
+	}, nil
+}
+
+// locations returns the catalogued files with a location.
+func (c *photoCatalog) locations() []imageInfo {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var infos []imageInfo
+	for _, entry := range c.entries {
+		if entry.Lat == nil || entry.Long == nil || entry.CopiedTo != "" {
+			continue
+		}
+		infos = append(infos, imageInfo{
+			Path:        entry.Path,
+			Time:        entry.Date,
+			HasLocation: true,
+			Lat:         *entry.Lat,
+			Long:        *entry.Long,
+			City:        entry.City,
+			Country:     entry.Country,
+		})
+	}
+	return infos
+}
+
+// save writes the catalog, sorted by path, replacing its file. Entries of
+// files that no longer exist are removed.
+func (c *photoCatalog) save() error {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	var paths []string
+	for path := range c.entries {
+		if _, err := os.Stat(path); os.IsNotExist(err) {
+			delete(c.entries, path)
+			continue
+		}
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+
+	tempFile := c.file + ".new"
+	f, err := os.Create(tempFile)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	encoder := json.NewEncoder(w)
+	for _, path := range paths {
+		if err := encoder.Encode(c.entries[path]); err != nil {
+			f.Close()
+			os.Remove(tempFile)
+			return err
+		}
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tempFile)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return os.Rename(tempFile, c.file)
+}
+
+// imageDimensions returns the width and height of the image in path, or 0, 0
+// if unknown.
+func imageDimensions(path string) (int, int) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".jpg", ".jpeg", ".png":
+		f, err := os.Open(path)
+		if err != nil {
+			return 0, 0
+		}
+		defer f.Close()
+		config, _, err := image.DecodeConfig(f)
+		if err != nil {
+			return 0, 0
+		}
+		return config.Width, config.Height
+	}
+
+	x, err := decodeExif(path)
+	if err != nil {
+		return 0, 0
+	}
+	var dimensions [2]int
+	for i, field := range []exif.FieldName{exif.PixelXDimension, exif.PixelYDimension} {
+		tag, err := x.Get(field)
+		if err != nil {
+			return 0, 0
+		}
+		dimensions[i], err = tag.Int(0)
+		if err != nil {
+			return 0, 0
+		}
+	}
+	return dimensions[0], dimensions[1]
+}
+
+// catalogQuery filters the entries of the catalog. Empty fields match all.
+type catalogQuery struct {
+	Year    int
+	From    time.Time
+	To      time.Time
+	Make    string
+	Model   string
+	City    string
+	Country string
+	Source  string
+}
+
+// matches returns true if entry matches q. Text fields match case insensitive
+// substrings, e.g. Model 'pixel 3' matches 'Pixel 3'.
+func (q catalogQuery) matches(entry catalogEntry) bool {
+	if q.Year != 0 && entry.Date.Year() != q.Year {
+		return false
+	}
+	if !q.From.IsZero() && entry.Date.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && !entry.Date.Before(q.To) {
+		return false
+	}
+
+	for _, field := range [][2]string{
+		{q.Make, entry.Make},
+		{q.Model, entry.Model},
+		{q.City, entry.City},
+		{q.Country, entry.Country},
+		{q.Source, entry.DateSource},
+	} {
+		if field[0] != "" && !strings.Contains(strings.ToLower(field[1]), strings.ToLower(field[0])) {
+			return false
+		}
+	}
+	return true
+}
+
+// runQuery is the query subcommand: it prints the catalog entries matching
+// the flags in args.
+func runQuery(args []string) {
+	flags := flag.NewFlagSet("query", flag.ExitOnError)
+	catalogFlag := flags.String("catalog", "", "Catalog file to query")
+	yearFlag := flags.Int("year", 0, "Year the photos were taken")
+	fromFlag := flags.String("from", "", "Taken on or after this date, e.g. 2019-01-02")
+	toFlag := flags.String("to", "", "Taken before this date, e.g. 2019-02-01")
+	makeFlag := flags.String("make", "", "Camera make contains this")
+	modelFlag := flags.String("model", "", "Camera model contains this, e.g. 'Pixel 3'")
+	cityFlag := flags.String("city", "", "City contains this")
+	countryFlag := flags.String("country", "", "Country contains this")
+	sourceFlag := flags.String("source", "", "Date source contains this, e.g. ModTime")
+	jsonFlag := flags.Bool("json", false, "Print the full entries as JSON lines instead of paths")
+	flags.Parse(args)
+
+	if *catalogFlag == "" {
+		log.Fatalln("query needs --catalog")
+	}
+	c, err := loadCatalog(*catalogFlag)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	q := catalogQuery{
+		Year:    *yearFlag,
+		Make:    *makeFlag,
+		Model:   *modelFlag,
+		City:    *cityFlag,
+		Country: *countryFlag,
+		Source:  *sourceFlag,
+	}
+	for _, date := range []struct {
+		value string
+		t     *time.Time
+	}{{*fromFlag, &q.From}, {*toFlag, &q.To}} {
+		if date.value == "" {
+			continue
+		}
+		*date.t, err = time.ParseInLocation("2006-01-02", date.value, time.Local)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	var matched []catalogEntry
+	for _, entry := range c.entries {
+		if entry.CopiedTo != "" {
+			// the copy has the metadata
+			continue
+		}
+		if q.matches(entry) {
+			matched = append(matched, entry)
+		}
+	}
+	sort.Slice(matched, func(i, j int) bool {
+		if !matched[i].Date.Equal(matched[j].Date) {
+			return matched[i].Date.Before(matched[j].Date)
+		}
+		return matched[i].Path < matched[j].Path
+	})
+
+	encoder := json.NewEncoder(os.Stdout)
+	for _, entry := range matched {
+		if *jsonFlag {
+			encoder.Encode(entry)
+		} else {
+			fmt.Println(entry.Path)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+)
+
+// duplicateError is returned when a file is the same photo as the file
+// already at its new name.
+type duplicateError struct {
+	Path     string
+	Existing string
+}
+
+func (e duplicateError) Error() string {
+	return fmt.Sprintf("'%v' is the same photo as '%v', not renamed", e.Path, e.Existing)
+}
+
+// collisionCandidates returns the names tried, in order, for a file whose name
+// without extension is base: base itself, base with the sub-second time of the
+// photo, and then base with a counter starting at 1. The counter is per base,
+// so each burst of photos is numbered on its own.
+func collisionCandidates(dir, base, ext, subSec string) func() string {
+	i := -1
+	return func() string {
+		i++
+		switch {
+		case i == 0:
+			return fmt.Sprintf("%s/%s%s", dir, base, ext)
+		case i == 1 && subSec != "":
+			return fmt.Sprintf("%s/%s.%s%s", dir, base, subSec, ext)
+		case subSec != "":
+			return fmt.Sprintf("%s/%s_%d%s", dir, base, i-1, ext)
+		default:
+			return fmt.Sprintf("%s/%s_%d%s", dir, base, i, ext)
+		}
+	}
+}
+
+// sameContent returns true if the files path and other have the same content.
+func sameContent(path string, other string) (bool, error) {
+	pathStat, err := os.Stat(path)
+	if err != nil {
+		return false, err
+	}
+	otherStat, err := os.Stat(other)
+	if err != nil {
+		return false, err
+	}
+	if os.SameFile(pathStat, otherStat) {
+		return true, nil
+	}
+	if pathStat.Size() != otherStat.Size() {
+		return false, nil
+	}
+
+	pathHash, err := fileHash(path)
+	if err != nil {
+		return false, err
+	}
+	otherHash, err := fileHash(other)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(pathHash, otherHash), nil
+}
+
+// fileHash returns the sha256 of the content of path.
+func fileHash(path string) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return nil, err
+	}
+	return h.Sum(nil), nil
+}
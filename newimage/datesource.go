@@ -0,0 +1,176 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	defaultDateSources = "original,digitized,datetime,gps,filename,mtime"
+)
+
+var (
+	dateSources = strings.Split(defaultDateSources, ",") // in order of priority
+)
+
+// fileMetadata is what the date sources read dates from.
+type fileMetadata struct {
+	Path  string
+	Exif  *exif.Exif     // nil if the file has no exif
+	Video *videoMetadata // nil if the file is not a video or has no metadata
+}
+
+// foundDate is a date read from a date source.
+type foundDate struct {
+	Time   time.Time
+	SubSec string
+	Source string
+}
+
+// dateSourceReaders reads the date of each date source.
+var dateSourceReaders = map[string]func(m fileMetadata) (foundDate, bool){
+	"original": func(m fileMetadata) (foundDate, bool) {
+		if m.Video != nil && !m.Video.Time.IsZero() {
+			return foundDate{Time: m.Video.Time, Source: "video creation time"}, true
+		}
+		if date, ok := exifDate(m.Exif, exif.DateTimeOriginal, exif.SubSecTimeOriginal, offsetTimeOriginal); ok {
+			return date, true
+		}
+		if strings.ToLower(filepath.Ext(m.Path)) == ".png" {
+			if t, err := pngTextTime(m.Path); err == nil {
+				return foundDate{Time: t, Source: "png Creation Time"}, true
+			}
+		}
+		return foundDate{}, false
+	},
+	"digitized": func(m fileMetadata) (foundDate, bool) {
+		return exifDate(m.Exif, exif.DateTimeDigitized, exif.SubSecTimeDigitized, offsetTimeDigitized)
+	},
+	"datetime": func(m fileMetadata) (foundDate, bool) {
+		return exifDate(m.Exif, exif.DateTime, exif.SubSecTime, offsetTime)
+	},
+	"gps": func(m fileMetadata) (foundDate, bool) {
+		return gpsDate(m.Exif)
+	},
+	"filename": func(m fileMetadata) (foundDate, bool) {
+		return filenameDate(m.Path)
+	},
+	"mtime": func(m fileMetadata) (foundDate, bool) {
+		fstat, err := os.Stat(m.Path)
+		if err != nil {
+			return foundDate{}, false
+		}
+		return foundDate{Time: fstat.ModTime(), Source: "ModTime"}, true
+	},
+}
+
+// filenameDatePatterns match the dates in names given by phones and apps.
+// Their groups are year, month, day and optionally hour, minute and second.
+var filenameDatePatterns = []*regexp.Regexp{
+	// IMG_20190102_101112, VID_20190102_101112, PXL_20190102_101112345, Screenshot_20190102-101112
+	regexp.MustCompile(`(?:^|[^0-9])((?:19|20)\d\d)(\d\d)(\d\d)[_-](\d\d)(\d\d)(\d\d)`),
+	// 2019-01-02 10.11.12, 2019-01-02 at 10.11.12, 2019-01-02_10-11-12
+	regexp.MustCompile(`(?:^|[^0-9])((?:19|20)\d\d)-(\d\d)-(\d\d)(?:[ _T]|_at_| at )(\d\d)[.:-](\d\d)[.:-](\d\d)`),
+	// IMG-20190102-WA0001
+	regexp.MustCompile(`(?:^|[^0-9])((?:19|20)\d\d)(\d\d)(\d\d)-WA\d+`),
+}
+
+// checkDateSources returns an error if sources has unknown date sources.
+func checkDateSources(sources []string) error {
+	if len(sources) == 0 {
+		return fmt.Errorf("no date sources")
+	}
+	for _, source := range sources {
+		if _, ok := dateSourceReaders[source]; !ok {
+			return fmt.Errorf("unknown date source '%v', use %v", source, defaultDateSources)
+		}
+	}
+	return nil
+}
+
+// exifDate reads the date in field of x, with the sub-seconds in subSecField
+// and the timezone in offsetField when present.
+func exifDate(x *exif.Exif, field, subSecField, offsetField exif.FieldName) (foundDate, bool) {
+	if x == nil {
+		return foundDate{}, false
+	}
+
+	value := exifString(x, field)
+	if value == "" || strings.HasPrefix(value, "0000") {
+		return foundDate{}, false
+	}
+	loc, ok := exifTimezone(x, offsetField)
+	if !ok {
+		loc = time.Local
+	}
+	t, err := time.ParseInLocation(exifDateLayout, value, loc)
+	if err != nil {
+		return foundDate{}, false
+	}
+
+	date := foundDate{Time: t, Source: string(field)}
+	date.SubSec = subSecond(x, subSecField)
+	if nsec, ok := subSecondNanoseconds(date.SubSec); ok {
+		date.Time = date.Time.Add(time.Duration(nsec))
+	}
+	return date, true
+}
+
+// gpsDate reads the UTC date of the GPS fix of x.
+func gpsDate(x *exif.Exif) (foundDate, bool) {
+	if x == nil {
+		return foundDate{}, false
+	}
+
+	day, err := time.Parse("2006:01:02", exifString(x, exif.GPSDateStamp))
+	if err != nil {
+		return foundDate{}, false
+	}
+	tag, err := x.Get(exif.GPSTimeStamp)
+	if err != nil {
+		return foundDate{}, false
+	}
+
+	var clock [3]float64
+	for i := range clock {
+		num, den, err := tag.Rat2(i)
+		if err != nil || den == 0 {
+			return foundDate{}, false
+		}
+		clock[i] = float64(num) / float64(den)
+	}
+
+	offset := time.Duration(clock[0]*float64(time.Hour) + clock[1]*float64(time.Minute) + clock[2]*float64(time.Second))
+	t := day.Add(offset.Round(time.Second)).In(time.Local)
+	return foundDate{Time: t, Source: "GPS"}, true
+}
+
+// filenameDate reads the date in the name of path, which is taken as local.
+func filenameDate(path string) (foundDate, bool) {
+	name := filepath.Base(path)
+	for _, pattern := range filenameDatePatterns {
+		match := pattern.FindStringSubmatch(name)
+		if match == nil {
+			continue
+		}
+
+		value := strings.Join(match[1:4], "-")
+		layout := "2006-01-02"
+		if len(match) > 4 {
+			value += " " + strings.Join(match[4:7], ":")
+			layout += " 15:04:05"
+		}
+		t, err := time.ParseInLocation(layout, value, time.Local)
+		if err != nil {
+			continue
+		}
+		return foundDate{Time: t, Source: "file name"}, true
+	}
+	return foundDate{}, false
+}
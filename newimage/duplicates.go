@@ -0,0 +1,353 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"image"
+	"log"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+)
+
+const (
+	DEFAULT_SIMILARITY = 4 // maximum dHash distance of near duplicates
+)
+
+var duplicateActions = map[string]bool{
+	"report":     true,
+	"hardlink":   true,
+	"quarantine": true,
+	"delete":     true,
+}
+
+var (
+	hashWorkerTotal = runtime.NumCPU() // Number of concurrent files being hashed
+)
+
+var (
+	hashWorkerWaitGroup sync.WaitGroup
+)
+
+// duplicateSettings configures the duplicate finder.
+type duplicateSettings struct {
+	Action        string
+	QuarantineDir string
+	Similarity    int  // maximum dHash distance of near duplicates, negative to only find exact duplicates
+	DeleteSimilar bool // quarantine and delete near duplicates too, not only exact ones
+}
+
+// mediaFile is a photo or video with what is needed to compare it to others.
+type mediaFile struct {
+	Path          string
+	Size          int64
+	Hash          string
+	DHash         uint64
+	HasDHash      bool
+	Width         int
+	Height        int
+	MetadataScore int // number of metadata fields present
+}
+
+func (f mediaFile) resolution() int {
+	return f.Width * f.Height
+}
+
+// better returns true if f is a better copy to keep than other.
+func (f mediaFile) better(other mediaFile) bool {
+	if f.resolution() != other.resolution() {
+		return f.resolution() > other.resolution()
+	}
+	if f.MetadataScore != other.MetadataScore {
+		return f.MetadataScore > other.MetadataScore
+	}
+	return f.Path < other.Path
+}
+
+// findDuplicates hashes the files under rootDir and applies settings.Action to
+// each copy that is not the best of its group of duplicates.
+func findDuplicates(rootDir string, isRecursive bool, settings duplicateSettings) error {
+	rootDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		return err
+	}
+
+	// the quarantine is not walked, so quarantined files are not found again
+	var excludeDir string
+	if settings.QuarantineDir != "" {
+		if excludeDir, err = filepath.Abs(settings.QuarantineDir); err != nil {
+			return err
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	paths, errc := walkFiles(done, rootDir, isRecursive, filetypesSupported, excludeDir)
+	files := hashFiles(done, paths)
+	if err := <-errc; err != nil {
+		return err
+	}
+
+	for _, group := range groupDuplicates(files, settings.Similarity) {
+		best := group[0]
+		fmt.Printf("Keep %v (%vx%v)\n", best.Path, best.Width, best.Height)
+		for _, f := range group[1:] {
+			if err := handleDuplicate(rootDir, best, f, settings); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+	return nil
+}
+
+// hashFiles hashes the files from paths concurrently and returns them sorted by path.
+func hashFiles(done <-chan struct{}, paths <-chan string) []mediaFile {
+	hashed := make(chan mediaFile)
+
+	hashWorkerWaitGroup.Add(hashWorkerTotal)
+	for i := 0; i < hashWorkerTotal; i++ {
+		go hashWorker(done, paths, hashed)
+	}
+	go func() {
+		hashWorkerWaitGroup.Wait()
+		close(hashed)
+	}()
+
+	var files []mediaFile
+	for f := range hashed {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i].Path < files[j].Path })
+	return files
+}
+
+func hashWorker(done <-chan struct{}, paths <-chan string, hashed chan<- mediaFile) {
+	defer hashWorkerWaitGroup.Done()
+
+	for path := range paths {
+		f, err := readMediaFile(path)
+		if err != nil {
+			log.Println(err)
+			continue
+		}
+
+		select {
+		case hashed <- f:
+		case <-done:
+			return
+		}
+	}
+}
+
+func readMediaFile(path string) (mediaFile, error) {
+	f := mediaFile{Path: path}
+
+	fstat, err := os.Stat(path)
+	if err != nil {
+		return f, err
+	}
+	f.Size = fstat.Size()
+
+	hash, err := fileHash(path)
+	if err != nil {
+		return f, err
+	}
+	f.Hash = hex.EncodeToString(hash)
+
+	if x, err := decodeExif(path); err == nil {
+		if _, err := x.DateTime(); err == nil {
+			f.MetadataScore++
+		}
+		if _, _, err := x.LatLong(); err == nil {
+			f.MetadataScore++
+		}
+		info, _ := readImageInfo(path)
+		for _, value := range []string{info.Make, info.Model, info.Lens} {
+			if value != "" {
+				f.MetadataScore++
+			}
+		}
+	}
+
+	if ext := strings.ToLower(filepath.Ext(path)); ext == ".jpg" || ext == ".jpeg" || ext == ".png" {
+		img, err := decodeImage(path)
+		if err != nil {
+			log.Println(err)
+			return f, nil
+		}
+		f.Width = img.Bounds().Dx()
+		f.Height = img.Bounds().Dy()
+		f.DHash = dHash(img)
+		f.HasDHash = true
+	}
+	return f, nil
+}
+
+func decodeImage(path string) (image.Image, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	img, _, err := image.Decode(file)
+	if err != nil {
+		return nil, fmt.Errorf("image.Decode '%v': %v", path, err)
+	}
+	return img, nil
+}
+
+// dHash returns the difference hash of img: the image is reduced to 9x8 gray
+// cells and each bit tells if a cell is brighter than its right neighbour.
+func dHash(img image.Image) uint64 {
+	const (
+		width   = 9
+		height  = 8
+		samples = 8 // points sampled per side of each cell
+	)
+
+	bounds := img.Bounds()
+	var cells [height][width]uint64
+	for cy := 0; cy < height; cy++ {
+		for cx := 0; cx < width; cx++ {
+			for sy := 0; sy < samples; sy++ {
+				for sx := 0; sx < samples; sx++ {
+					x := bounds.Min.X + (cx*samples+sx)*bounds.Dx()/(width*samples)
+					y := bounds.Min.Y + (cy*samples+sy)*bounds.Dy()/(height*samples)
+					r, g, b, _ := img.At(x, y).RGBA()
+					cells[cy][cx] += uint64(299*r+587*g+114*b) / 1000
+				}
+			}
+		}
+	}
+
+	var hash uint64
+	for y := 0; y < height; y++ {
+		for x := 0; x < width-1; x++ {
+			hash <<= 1
+			if cells[y][x] > cells[y][x+1] {
+				hash |= 1
+			}
+		}
+	}
+	return hash
+}
+
+// groupDuplicates groups files with the same content, or, if similarity is not
+// negative, with a dHash at most similarity bits apart from the best copy of
+// the group. Each group has the best copy first.
+func groupDuplicates(files []mediaFile, similarity int) [][]mediaFile {
+	byHash := make(map[string][]mediaFile)
+	var hashes []string
+	for _, f := range files {
+		if _, ok := byHash[f.Hash]; !ok {
+			hashes = append(hashes, f.Hash)
+		}
+		byHash[f.Hash] = append(byHash[f.Hash], f)
+	}
+
+	// one copy of each content, best first, so each group is built around the
+	// best copy instead of a chain of files each similar to the previous one
+	exact := make([][]mediaFile, len(hashes))
+	for i, hash := range hashes {
+		exact[i] = byHash[hash]
+		sort.Slice(exact[i], func(a, b int) bool { return exact[i][a].better(exact[i][b]) })
+	}
+	sort.SliceStable(exact, func(a, b int) bool { return exact[a][0].better(exact[b][0]) })
+
+	grouped := make([]bool, len(exact))
+	var groups [][]mediaFile
+	for i := range exact {
+		if grouped[i] {
+			continue
+		}
+		grouped[i] = true
+		best := exact[i][0]
+		group := append([]mediaFile{}, exact[i]...)
+
+		if similarity >= 0 && best.HasDHash {
+			for j := i + 1; j < len(exact); j++ {
+				other := exact[j][0]
+				if !grouped[j] && other.HasDHash && bits.OnesCount64(best.DHash^other.DHash) <= similarity {
+					grouped[j] = true
+					group = append(group, exact[j]...)
+				}
+			}
+		}
+
+		if len(group) < 2 {
+			continue
+		}
+		sort.SliceStable(group[1:], func(a, b int) bool { return group[1+a].better(group[1+b]) })
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+// handleDuplicate applies settings.Action to f, a duplicate of best.
+func handleDuplicate(rootDir string, best mediaFile, f mediaFile, settings duplicateSettings) error {
+	kind := "exact"
+	if f.Hash != best.Hash {
+		kind = fmt.Sprintf("similar, distance %d", bits.OnesCount64(f.DHash^best.DHash))
+	}
+
+	switch settings.Action {
+	case "report":
+		fmt.Printf("  %v (%vx%v, %v)\n", f.Path, f.Width, f.Height, kind)
+		return nil
+	case "hardlink":
+		if f.Hash != best.Hash {
+			fmt.Printf("  %v (%v) is not identical, not hardlinked\n", f.Path, kind)
+			return nil
+		}
+		tempFile := f.Path + ".newimage-link"
+		if err := os.Link(best.Path, tempFile); err != nil {
+			return err
+		}
+		if err := os.Rename(tempFile, f.Path); err != nil {
+			os.Remove(tempFile)
+			return err
+		}
+		fmt.Printf("  %v (%v) -> hardlink\n", f.Path, kind)
+		return nil
+	case "quarantine":
+		if f.Hash != best.Hash && !settings.DeleteSimilar {
+			fmt.Printf("  %v (%v) is not identical, not quarantined without --delete-similar\n", f.Path, kind)
+			return nil
+		}
+		rel, err := filepath.Rel(rootDir, f.Path)
+		if err != nil {
+			return err
+		}
+		newFile := filepath.Join(settings.QuarantineDir, rel)
+		if err := os.MkdirAll(filepath.Dir(newFile), 0755); err != nil {
+			return err
+		}
+		if _, err := os.Stat(newFile); err == nil {
+			return fmt.Errorf("'%v' already exists, not overwriting it with '%v'", newFile, f.Path)
+		}
+		// the quarantine may be on another device
+		if err := moveFile(f.Path, newFile); err != nil {
+			return err
+		}
+		fmt.Printf("  %v (%v) -> %v\n", f.Path, kind, newFile)
+		return nil
+	case "delete":
+		if f.Hash != best.Hash && !settings.DeleteSimilar {
+			fmt.Printf("  %v (%v) is not identical, not deleted without --delete-similar\n", f.Path, kind)
+			return nil
+		}
+		if err := os.Remove(f.Path); err != nil {
+			return err
+		}
+		fmt.Printf("  %v (%v) deleted\n", f.Path, kind)
+		return nil
+	default:
+		return fmt.Errorf("unknown duplicates action '%v'", settings.Action)
+	}
+}
@@ -0,0 +1,378 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+var rawFiletypes = map[string]bool{
+	".cr2": true,
+	".nef": true,
+	".arw": true,
+	".dng": true,
+}
+
+var heicFiletypes = map[string]bool{
+	".heic": true,
+	".heif": true,
+}
+
+// pairedFiletypes are renamed together with a raw file of the same name.
+var pairedFiletypes = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".heic": true,
+}
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// pngDateLayouts are the layouts seen in the 'Creation Time' text of pngs.
+var pngDateLayouts = []string{
+	time.RFC1123Z,
+	time.RFC1123,
+	time.RFC3339,
+	"2006:01:02 15:04:05",
+	"2006-01-02T15:04:05",
+	"2006-01-02 15:04:05",
+}
+
+// exifData returns the exif of f in a form exif.Decode reads: the file itself
+// for jpgs and tiff based raw files, or the tiff data of the exif of heic and
+// png files.
+func exifData(f *os.File) (io.Reader, error) {
+	switch ext := strings.ToLower(filepath.Ext(f.Name())); {
+	case heicFiletypes[ext]:
+		data, err := heicExif(f)
+		if err != nil {
+			return nil, err
+		}
+		return bytes.NewReader(data), nil
+	case ext == ".png":
+		chunks, err := pngChunks(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, chunk := range chunks {
+			if chunk.Type == "eXIf" {
+				return bytes.NewReader(chunk.Data), nil
+			}
+		}
+		return nil, errors.New("png has no eXIf chunk")
+	default:
+		return f, nil
+	}
+}
+
+// heicExif returns the tiff data of the Exif item of a HEIC/HEIF file.
+func heicExif(f *os.File) ([]byte, error) {
+	meta, err := readTopLevelAtom(f, "meta")
+	if err != nil {
+		return nil, err
+	}
+	if len(meta) < 4 {
+		return nil, errors.New("invalid meta atom")
+	}
+	meta = meta[4:] // version and flags
+
+	itemID, ok := heicExifItemID(findAtom(meta, "iinf"))
+	if !ok {
+		return nil, errors.New("heic has no Exif item")
+	}
+
+	data, err := heicItemData(f, meta, itemID)
+	if err != nil {
+		return nil, err
+	}
+
+	// the item starts with the offset of the tiff header, after 'Exif\0\0'
+	if len(data) < 4 {
+		return nil, errors.New("invalid Exif item")
+	}
+	offset := 4 + int(binary.BigEndian.Uint32(data[:4]))
+	if offset > len(data) {
+		return nil, errors.New("invalid Exif item")
+	}
+	return data[offset:], nil
+}
+
+// heicExifItemID returns the id of the Exif item in the payload of an iinf atom.
+func heicExifItemID(iinf []byte) (uint32, bool) {
+	if len(iinf) < 6 {
+		return 0, false
+	}
+	entries := iinf[6:]
+	if iinf[0] != 0 {
+		if len(iinf) < 8 {
+			return 0, false
+		}
+		entries = iinf[8:]
+	}
+
+	for _, infe := range findAtoms(entries, "infe") {
+		if len(infe) < 4 {
+			continue
+		}
+		switch version := infe[0]; version {
+		case 2:
+			if len(infe) >= 12 && string(infe[8:12]) == "Exif" {
+				return uint32(binary.BigEndian.Uint16(infe[4:6])), true
+			}
+		case 3:
+			if len(infe) >= 14 && string(infe[10:14]) == "Exif" {
+				return binary.BigEndian.Uint32(infe[4:8]), true
+			}
+		}
+	}
+	return 0, false
+}
+
+// heicItemData reads the data of the item itemID from the extents listed in
+// the iloc atom of meta.
+func heicItemData(f *os.File, meta []byte, itemID uint32) ([]byte, error) {
+	iloc := findAtom(meta, "iloc")
+	if len(iloc) < 8 {
+		return nil, errors.New("heic has no iloc atom")
+	}
+
+	r := &byteReader{data: iloc}
+	version := r.uint(1)
+	r.uint(3) // flags
+	sizes := r.uint(1)
+	offsetSize, lengthSize := int(sizes>>4), int(sizes&0xf)
+	sizes = r.uint(1)
+	baseOffsetSize, indexSize := int(sizes>>4), int(sizes&0xf)
+	if version == 0 {
+		indexSize = 0
+	}
+
+	var itemCount uint64
+	if version < 2 {
+		itemCount = r.uint(2)
+	} else {
+		itemCount = r.uint(4)
+	}
+
+	for i := uint64(0); i < itemCount && r.err == nil; i++ {
+		var id uint64
+		if version < 2 {
+			id = r.uint(2)
+		} else {
+			id = r.uint(4)
+		}
+		var constructionMethod uint64
+		if version > 0 {
+			constructionMethod = r.uint(2) & 0xf
+		}
+		r.uint(2) // data reference index
+		baseOffset := r.uint(baseOffsetSize)
+		extentCount := r.uint(2)
+
+		var data []byte
+		for e := uint64(0); e < extentCount && r.err == nil; e++ {
+			r.uint(indexSize)
+			extentOffset := r.uint(offsetSize)
+			extentLength := r.uint(lengthSize)
+			if uint32(id) != itemID {
+				continue
+			}
+			if extentLength > MAX_ATOM_SIZE-uint64(len(data)) {
+				return nil, errors.New("Exif item is too big")
+			}
+			// the offsets come from the file, they may overflow
+			start := baseOffset + extentOffset
+			if start < baseOffset || start > math.MaxInt64 {
+				return nil, errors.New("invalid iloc extent offset")
+			}
+
+			extent := make([]byte, extentLength)
+			switch constructionMethod {
+			case 0: // offset in the file
+				if _, err := f.ReadAt(extent, int64(start)); err != nil {
+					return nil, err
+				}
+			case 1: // offset in the idat atom
+				idat := findAtom(meta, "idat")
+				if start > uint64(len(idat)) || extentLength > uint64(len(idat))-start {
+					return nil, errors.New("invalid idat extent")
+				}
+				copy(extent, idat[start:])
+			default:
+				return nil, fmt.Errorf("iloc construction method %v is not supported", constructionMethod)
+			}
+			data = append(data, extent...)
+		}
+		if uint32(id) == itemID {
+			return data, r.err
+		}
+	}
+	if r.err != nil {
+		return nil, r.err
+	}
+	return nil, fmt.Errorf("heic item %v not found in iloc", itemID)
+}
+
+// byteReader reads big endian integers of any size, remembering the first error.
+type byteReader struct {
+	data []byte
+	err  error
+}
+
+func (r *byteReader) uint(size int) uint64 {
+	if r.err != nil {
+		return 0
+	}
+	if size > len(r.data) {
+		r.err = io.ErrUnexpectedEOF
+		return 0
+	}
+
+	var value uint64
+	for _, b := range r.data[:size] {
+		value = value<<8 | uint64(b)
+	}
+	r.data = r.data[size:]
+	return value
+}
+
+// pngChunk is a chunk of a png file.
+type pngChunk struct {
+	Type string
+	Data []byte
+}
+
+// pngChunks returns the metadata chunks of the png f, the image data is skipped.
+func pngChunks(f *os.File) ([]pngChunk, error) {
+	signature := make([]byte, len(pngSignature))
+	if _, err := io.ReadFull(f, signature); err != nil {
+		return nil, err
+	}
+	if !bytes.Equal(signature, pngSignature) {
+		return nil, errors.New("not a png")
+	}
+
+	var chunks []pngChunk
+	header := make([]byte, 8)
+	for {
+		if _, err := io.ReadFull(f, header); err != nil {
+			return nil, err
+		}
+		length := int64(binary.BigEndian.Uint32(header[:4]))
+		typ := string(header[4:8])
+
+		switch typ {
+		case "IEND":
+			return chunks, nil
+		case "eXIf", "tEXt", "iTXt":
+			if length > MAX_ATOM_SIZE {
+				return nil, fmt.Errorf("png %v chunk is too big", typ)
+			}
+			data := make([]byte, length)
+			if _, err := io.ReadFull(f, data); err != nil {
+				return nil, err
+			}
+			chunks = append(chunks, pngChunk{Type: typ, Data: data})
+			length = 0
+		}
+
+		// skip the data and the crc
+		if _, err := f.Seek(length+4, io.SeekCurrent); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// pngTextTime returns the date in the 'Creation Time' text of the png in path.
+func pngTextTime(path string) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	chunks, err := pngChunks(f)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("'%v': %v", path, err)
+	}
+
+	for _, chunk := range chunks {
+		keyword, text, ok := pngText(chunk)
+		if !ok || keyword != "Creation Time" {
+			continue
+		}
+		for _, layout := range pngDateLayouts {
+			if t, err := time.ParseInLocation(layout, text, time.Local); err == nil {
+				return t, nil
+			}
+		}
+	}
+	return time.Time{}, fmt.Errorf("'%v' has no creation time", path)
+}
+
+// pngText returns the keyword and the text of an uncompressed tEXt or iTXt chunk.
+func pngText(chunk pngChunk) (string, string, bool) {
+	parts := bytes.SplitN(chunk.Data, []byte{0}, 2)
+	if len(parts) != 2 {
+		return "", "", false
+	}
+	keyword, text := string(parts[0]), parts[1]
+
+	switch chunk.Type {
+	case "tEXt":
+		return keyword, strings.TrimSpace(string(text)), true
+	case "iTXt":
+		// compression flag, compression method, language\0, translated keyword\0
+		if len(text) < 2 || text[0] != 0 {
+			return "", "", false
+		}
+		fields := bytes.SplitN(text[2:], []byte{0}, 3)
+		if len(fields) != 3 {
+			return "", "", false
+		}
+		return keyword, strings.TrimSpace(string(fields[2])), true
+	default:
+		return "", "", false
+	}
+}
+
+// pairedFiles returns the files renamed together with path: the jpg or heic of
+// a raw file, or the raw file of a jpg or heic, with the same name in the same
+// directory.
+func pairedFiles(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	var isPair func(ext string) bool
+	switch {
+	case rawFiletypes[strings.ToLower(ext)]:
+		isPair = func(ext string) bool { return pairedFiletypes[strings.ToLower(ext)] }
+	case pairedFiletypes[strings.ToLower(ext)]:
+		isPair = func(ext string) bool { return rawFiletypes[strings.ToLower(ext)] }
+	default:
+		return nil, nil
+	}
+
+	infos, err := ioutil.ReadDir(filepath.Dir(path))
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(path)
+	base = base[:len(base)-len(ext)]
+	var paired []string
+	for _, info := range infos {
+		name := info.Name()
+		nameExt := filepath.Ext(name)
+		if info.IsDir() || name[:len(name)-len(nameExt)] != base || !isPair(nameExt) {
+			continue
+		}
+		paired = append(paired, filepath.Join(filepath.Dir(path), name))
+	}
+	return paired, nil
+}
@@ -0,0 +1,181 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// testExtent is an extent of an item in a test iloc atom.
+type testExtent struct {
+	Offset uint64
+	Length uint32
+}
+
+// testIlocItem is an item of a test iloc atom.
+type testIlocItem struct {
+	ID                 uint16
+	ConstructionMethod uint16
+	BaseOffset         uint64
+	Extents            []testExtent
+}
+
+// testIloc returns a version 1 iloc atom with 8 byte offsets and 4 byte lengths.
+func testIloc(items ...testIlocItem) []byte {
+	var payload bytes.Buffer
+	payload.Write([]byte{1, 0, 0, 0}) // version and flags
+	payload.Write([]byte{0x84, 0x80}) // offset, length, base offset and index sizes
+	binary.Write(&payload, binary.BigEndian, uint16(len(items)))
+	for _, item := range items {
+		binary.Write(&payload, binary.BigEndian, item.ID)
+		binary.Write(&payload, binary.BigEndian, item.ConstructionMethod)
+		binary.Write(&payload, binary.BigEndian, uint16(0)) // data reference index
+		binary.Write(&payload, binary.BigEndian, item.BaseOffset)
+		binary.Write(&payload, binary.BigEndian, uint16(len(item.Extents)))
+		for _, extent := range item.Extents {
+			binary.Write(&payload, binary.BigEndian, extent.Offset)
+			binary.Write(&payload, binary.BigEndian, extent.Length)
+		}
+	}
+	return testAtom("iloc", payload.Bytes())
+}
+
+// testInfe returns a version 2 infe atom of an item of itemType.
+func testInfe(id uint16, itemType string) []byte {
+	payload := []byte{2, 0, 0, 0, byte(id >> 8), byte(id), 0, 0}
+	return testAtom("infe", append(append(payload, itemType...), 0))
+}
+
+// testIinf returns a version 0 iinf atom with infes.
+func testIinf(infes ...[]byte) []byte {
+	return testAtom("iinf", []byte{0, 0, 0, 0, 0, byte(len(infes))}, bytes.Join(infes, nil))
+}
+
+// writeTestFile writes data to name in dir and returns it open.
+func writeTestFile(t *testing.T, dir string, name string, data []byte) *os.File {
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestHeicExifItemID(t *testing.T) {
+	version3 := testAtom("infe", []byte{3, 0, 0, 0, 0, 0, 1, 2, 0, 0}, []byte("Exif"))
+
+	tests := []struct {
+		name string
+		iinf []byte
+		id   uint32
+		ok   bool
+	}{
+		{"version 2", testIinf(testInfe(1, "hvc1"), testInfe(7, "Exif")), 7, true},
+		{"version 3", testIinf(version3), 258, true},
+		{"iinf version 1", testAtom("iinf", []byte{1, 0, 0, 0, 0, 0, 0, 1}, testInfe(3, "Exif")), 3, true},
+		{"no Exif", testIinf(testInfe(1, "hvc1")), 0, false},
+		{"short infe", testIinf(testAtom("infe", []byte{2, 0})), 0, false},
+		{"empty", testAtom("iinf"), 0, false},
+	}
+
+	for _, test := range tests {
+		id, ok := heicExifItemID(parseAtoms(test.iinf)[0].Data)
+		if id != test.id || ok != test.ok {
+			t.Errorf("%v: heicExifItemID() = %v, %v, want %v, %v", test.name, id, ok, test.id, test.ok)
+		}
+	}
+}
+
+func TestHeicItemData(t *testing.T) {
+	dir, err := ioutil.TempDir("", "newimage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	f := writeTestFile(t, dir, "a.heic", []byte("0123456789"))
+	defer f.Close()
+	idat := testAtom("idat", []byte("abcdef"))
+
+	tests := []struct {
+		name string
+		meta []byte
+		data string
+		err  bool
+	}{
+		{"file offset", testIloc(testIlocItem{ID: 1, BaseOffset: 2, Extents: []testExtent{{1, 3}}}), "345", false},
+		{"idat offset", append(testIloc(testIlocItem{ID: 1, ConstructionMethod: 1, Extents: []testExtent{{2, 2}}}), idat...), "cd", false},
+		{"extents", testIloc(testIlocItem{ID: 1, Extents: []testExtent{{0, 2}, {8, 2}}}), "0189", false},
+		{"other items first", testIloc(testIlocItem{ID: 2, Extents: []testExtent{{0, 2}}}, testIlocItem{ID: 1, Extents: []testExtent{{5, 1}}}), "5", false},
+		{"not found", testIloc(testIlocItem{ID: 2, Extents: []testExtent{{0, 2}}}), "", true},
+		{"no iloc", idat, "", true},
+		{"past the end of the file", testIloc(testIlocItem{ID: 1, Extents: []testExtent{{8, 4}}}), "", true},
+		{"past the end of idat", append(testIloc(testIlocItem{ID: 1, ConstructionMethod: 1, Extents: []testExtent{{4, 3}}}), idat...), "", true},
+		{"idat offset overflow", append(testIloc(testIlocItem{ID: 1, ConstructionMethod: 1, BaseOffset: 1 << 63, Extents: []testExtent{{1 << 63, 2}}}), idat...), "", true},
+		{"idat length overflow", append(testIloc(testIlocItem{ID: 1, ConstructionMethod: 1, BaseOffset: 2, Extents: []testExtent{{^uint64(0) - 3, 4}}}), idat...), "", true},
+		{"file offset overflow", testIloc(testIlocItem{ID: 1, BaseOffset: ^uint64(0), Extents: []testExtent{{2, 2}}}), "", true},
+		{"too big", testIloc(testIlocItem{ID: 1, Extents: []testExtent{{0, ^uint32(0)}}}), "", true},
+		{"truncated", testIloc(testIlocItem{ID: 1, Extents: []testExtent{{0, 2}}})[:20], "", true},
+		{"unknown construction method", testIloc(testIlocItem{ID: 1, ConstructionMethod: 2, Extents: []testExtent{{0, 2}}}), "", true},
+	}
+
+	for _, test := range tests {
+		data, err := heicItemData(f, test.meta, 1)
+		if (err != nil) != test.err {
+			t.Errorf("%v: heicItemData() error = %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if string(data) != test.data {
+			t.Errorf("%v: heicItemData() = %q, want %q", test.name, data, test.data)
+		}
+	}
+}
+
+func TestHeicExif(t *testing.T) {
+	dir, err := ioutil.TempDir("", "newimage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	item := append([]byte{0, 0, 0, 6}, append([]byte("Exif\x00\x00"), tiff...)...)
+	meta := func(item []byte) []byte {
+		return testAtom("meta", []byte{0, 0, 0, 0},
+			testAtom("hdlr", make([]byte, 24)),
+			testIinf(testInfe(1, "hvc1"), testInfe(2, "Exif")),
+			testIloc(testIlocItem{ID: 2, ConstructionMethod: 1, Extents: []testExtent{{0, uint32(len(item))}}}),
+			testAtom("idat", item))
+	}
+
+	tests := []struct {
+		name string
+		data []byte
+		tiff []byte
+		err  bool
+	}{
+		{"Exif item", append(testAtom("ftyp", []byte("heic")), meta(item)...), tiff, false},
+		{"invalid tiff offset", meta([]byte{0, 0, 1, 0, 'E'}), nil, true},
+		{"no meta", testAtom("ftyp", []byte("heic")), nil, true},
+		{"no Exif item", testAtom("meta", []byte{0, 0, 0, 0}, testIinf(testInfe(1, "hvc1"))), nil, true},
+	}
+
+	for i, test := range tests {
+		f := writeTestFile(t, dir, string(rune('a'+i))+".heic", test.data)
+		data, err := heicExif(f)
+		f.Close()
+		if (err != nil) != test.err {
+			t.Errorf("%v: heicExif() error = %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if !bytes.Equal(data, test.tiff) {
+			t.Errorf("%v: heicExif() = %q, want %q", test.name, data, test.tiff)
+		}
+	}
+}
@@ -0,0 +1,424 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"html/template"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mateusbraga/tools/executil"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	DEFAULT_THUMBNAIL_SIZE = 256
+	DEFAULT_SHEET_COLUMNS  = 6
+	DEFAULT_SHEET_ROWS     = 8
+	thumbnailQuality       = 85
+	thumbnailSamples       = 4 // points sampled per side of each thumbnail pixel
+)
+
+var (
+	galleryWorkerTotal = runtime.NumCPU() // Number of concurrent thumbnails being made
+)
+
+var (
+	galleryWorkerWaitGroup sync.WaitGroup
+)
+
+// gallerySettings configures the gallery subcommand.
+type gallerySettings struct {
+	RootDir       string
+	CacheDir      string // thumbnails of RootDir/a/b.jpg go to CacheDir/a/b.jpg.jpg
+	ThumbnailSize int
+}
+
+// galleryItem is a file of the gallery.
+type galleryItem struct {
+	Path      string
+	Thumbnail string // "" if the file has no thumbnail, e.g. videos
+	Info      imageInfo
+}
+
+// runGallery is the gallery subcommand: it makes thumbnails of the photos
+// and, per directory, contact sheets and an HTML gallery.
+func runGallery(args []string) {
+	flags := flag.NewFlagSet("gallery", flag.ExitOnError)
+	cacheFlag := flags.String("cache", "", "Directory of the thumbnails, default .thumbnails inside the walked directory")
+	sizeFlag := flags.Int("size", DEFAULT_THUMBNAIL_SIZE, "Largest side of the thumbnails, in pixels")
+	htmlFlag := flags.Bool("html", true, "Write an index.html gallery in each directory")
+	sheetFlag := flags.Bool("contact-sheet", false, "Write contact sheet jpgs of each directory into the cache, needs ImageMagick montage")
+	columnsFlag := flags.Int("columns", DEFAULT_SHEET_COLUMNS, "Thumbnails per row of the contact sheets")
+	rowsFlag := flags.Int("rows", DEFAULT_SHEET_ROWS, "Rows of each contact sheet")
+	flags.Parse(args)
+
+	if *sheetFlag {
+		if err := executil.HasExecutables("montage"); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	rootDir := "."
+	isRecursive := false
+	if flags.NArg() > 0 {
+		rootDir = flags.Arg(0)
+		if rootDir == "./..." {
+			rootDir = "."
+			isRecursive = true
+		}
+	}
+	rootDir, err := filepath.Abs(rootDir)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	settings := gallerySettings{
+		RootDir:       rootDir,
+		CacheDir:      filepath.Join(rootDir, ".thumbnails"),
+		ThumbnailSize: *sizeFlag,
+	}
+	if *cacheFlag != "" {
+		settings.CacheDir, err = filepath.Abs(*cacheFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	paths, errc := walkFiles(done, rootDir, isRecursive, filetypesSupported, settings.CacheDir)
+	itemsByDir := makeThumbnails(done, paths, settings)
+	if err := <-errc; err != nil {
+		log.Fatalln(err)
+	}
+
+	var dirs []string
+	for dir := range itemsByDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		items := itemsByDir[dir]
+		sort.Slice(items, func(i, j int) bool {
+			if !items[i].Info.Time.Equal(items[j].Info.Time) {
+				return items[i].Info.Time.Before(items[j].Info.Time)
+			}
+			return items[i].Path < items[j].Path
+		})
+
+		if *htmlFlag {
+			if err := writeGalleryHtml(dir, items, settings.ThumbnailSize); err != nil {
+				fmt.Println(err)
+			}
+		}
+		if *sheetFlag {
+			if err := writeContactSheets(dir, thumbnailDir(dir, settings), items, *columnsFlag, *rowsFlag); err != nil {
+				fmt.Println(err)
+			}
+		}
+	}
+}
+
+// makeThumbnails makes the thumbnails of the files from paths concurrently
+// and returns them by directory.
+func makeThumbnails(done <-chan struct{}, paths <-chan string, settings gallerySettings) map[string][]galleryItem {
+	made := make(chan galleryItem)
+
+	galleryWorkerWaitGroup.Add(galleryWorkerTotal)
+	for i := 0; i < galleryWorkerTotal; i++ {
+		go galleryWorker(done, paths, made, settings)
+	}
+	go func() {
+		galleryWorkerWaitGroup.Wait()
+		close(made)
+	}()
+
+	itemsByDir := make(map[string][]galleryItem)
+	for item := range made {
+		dir := filepath.Dir(item.Path)
+		itemsByDir[dir] = append(itemsByDir[dir], item)
+	}
+	return itemsByDir
+}
+
+func galleryWorker(done <-chan struct{}, paths <-chan string, made chan<- galleryItem, settings gallerySettings) {
+	defer galleryWorkerWaitGroup.Done()
+
+	for path := range paths {
+		item := galleryItem{Path: path}
+
+		info, err := readImageInfo(path)
+		if err != nil {
+			log.Println(err)
+		}
+		item.Info = info
+
+		if !videoFiletypes[strings.ToLower(filepath.Ext(path))] {
+			// the extension is kept so a raw file and its jpg get different thumbnails
+			thumbnail := filepath.Join(thumbnailDir(filepath.Dir(path), settings), filepath.Base(path)+".jpg")
+			if err := makeThumbnail(path, thumbnail, settings.ThumbnailSize); err != nil {
+				log.Println(err)
+			} else {
+				item.Thumbnail = thumbnail
+			}
+		}
+
+		select {
+		case made <- item:
+		case <-done:
+			return
+		}
+	}
+}
+
+// thumbnailDir returns the directory of the thumbnails of the files in dir.
+func thumbnailDir(dir string, settings gallerySettings) string {
+	rel, err := filepath.Rel(settings.RootDir, dir)
+	if err != nil {
+		rel = ""
+	}
+	return filepath.Join(settings.CacheDir, rel)
+}
+
+// makeThumbnail writes the thumbnail of path to thumbnail, unless it is newer
+// than path. The exif thumbnail is used when present.
+func makeThumbnail(path string, thumbnail string, size int) error {
+	fstat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	if tstat, err := os.Stat(thumbnail); err == nil && tstat.ModTime().After(fstat.ModTime()) {
+		return nil
+	}
+
+	var img image.Image
+	orientation := 1
+	if x, err := decodeExif(path); err == nil {
+		if tag, err := x.Get(exif.Orientation); err == nil {
+			if value, err := tag.Int(0); err == nil {
+				orientation = value
+			}
+		}
+		if data, err := x.JpegThumbnail(); err == nil {
+			img, _ = jpeg.Decode(bytes.NewReader(data))
+		}
+	}
+	if img == nil {
+		img, err = decodeImage(path)
+		if err != nil {
+			return err
+		}
+	}
+	img = orientImage(resizeImage(img, size), orientation)
+
+	if err := os.MkdirAll(filepath.Dir(thumbnail), 0755); err != nil {
+		return err
+	}
+	f, err := os.Create(thumbnail)
+	if err != nil {
+		return err
+	}
+	if err := jpeg.Encode(f, img, &jpeg.Options{Quality: thumbnailQuality}); err != nil {
+		f.Close()
+		os.Remove(thumbnail)
+		return err
+	}
+	return f.Close()
+}
+
+// resizeImage returns img scaled down, keeping its aspect ratio, so its
+// largest side is at most size. Each pixel averages a grid of samples of img.
+func resizeImage(img image.Image, size int) *image.RGBA {
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	if width > size || height > size {
+		if width >= height {
+			width, height = size, height*size/width
+		} else {
+			width, height = width*size/height, size
+		}
+	}
+	if width < 1 {
+		width = 1
+	}
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var r, g, b, n uint32
+			for sy := 0; sy < thumbnailSamples; sy++ {
+				for sx := 0; sx < thumbnailSamples; sx++ {
+					srcX := bounds.Min.X + ((x*thumbnailSamples+sx)*bounds.Dx())/(width*thumbnailSamples)
+					srcY := bounds.Min.Y + ((y*thumbnailSamples+sy)*bounds.Dy())/(height*thumbnailSamples)
+					pr, pg, pb, _ := img.At(srcX, srcY).RGBA()
+					r, g, b, n = r+pr, g+pg, b+pb, n+1
+				}
+			}
+			dst.Set(x, y, color.RGBA64{R: uint16(r / n), G: uint16(g / n), B: uint16(b / n), A: 0xffff})
+		}
+	}
+	return dst
+}
+
+// orientImage returns img displayed as the exif orientation says.
+func orientImage(img *image.RGBA, orientation int) *image.RGBA {
+	if orientation < 2 || orientation > 8 {
+		return img
+	}
+
+	bounds := img.Bounds()
+	width, height := bounds.Dx(), bounds.Dy()
+	transposed := orientation >= 5 // 5 to 8 swap width and height
+	dstWidth, dstHeight := width, height
+	if transposed {
+		dstWidth, dstHeight = height, width
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, dstWidth, dstHeight))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			var dx, dy int
+			switch orientation {
+			case 2: // mirrored horizontally
+				dx, dy = width-1-x, y
+			case 3: // rotated 180
+				dx, dy = width-1-x, height-1-y
+			case 4: // mirrored vertically
+				dx, dy = x, height-1-y
+			case 5: // transposed
+				dx, dy = y, x
+			case 6: // rotated 90 clockwise
+				dx, dy = height-1-y, x
+			case 7: // transversed
+				dx, dy = height-1-y, width-1-x
+			case 8: // rotated 90 counterclockwise
+				dx, dy = y, width-1-x
+			}
+			dst.Set(dx, dy, img.At(bounds.Min.X+x, bounds.Min.Y+y))
+		}
+	}
+	return dst
+}
+
+var galleryTemplate = template.Must(template.New("gallery").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: sans-serif; background: #222; color: #ddd; }
+figure { display: inline-block; margin: 8px; width: {{.Size}}px; vertical-align: top; }
+figure img { max-width: {{.Size}}px; max-height: {{.Size}}px; }
+figcaption { font-size: 12px; }
+a { color: #ddd; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{range .Items}}<figure>
+<a href="{{.Href}}">{{if .Src}}<img src="{{.Src}}" loading="lazy" alt="{{.Name}}">{{else}}{{.Name}}{{end}}</a>
+<figcaption>{{.Date}}<br>{{.Camera}}{{if .Place}}<br>{{.Place}}{{end}}</figcaption>
+</figure>
+{{end}}
+</body>
+</html>
+`))
+
+// writeGalleryHtml writes the index.html gallery of the items of dir, with
+// thumbnails of size pixels.
+func writeGalleryHtml(dir string, items []galleryItem, size int) error {
+	type htmlItem struct {
+		Name, Href, Src, Date, Camera, Place string
+	}
+	data := struct {
+		Title string
+		Size  int
+		Items []htmlItem
+	}{Title: filepath.Base(dir), Size: size}
+
+	for _, item := range items {
+		h := htmlItem{
+			Name:   filepath.Base(item.Path),
+			Href:   filepath.ToSlash(filepath.Base(item.Path)),
+			Date:   item.Info.Time.Format("2006-01-02 15:04:05"),
+			Camera: strings.TrimSpace(item.Info.Make + " " + item.Info.Model),
+			Place:  strings.Trim(item.Info.City+", "+item.Info.Country, ", "),
+		}
+		if item.Thumbnail != "" {
+			if rel, err := filepath.Rel(dir, item.Thumbnail); err == nil {
+				h.Src = filepath.ToSlash(rel)
+			}
+		}
+		data.Items = append(data.Items, h)
+	}
+
+	var buf bytes.Buffer
+	if err := galleryTemplate.Execute(&buf, data); err != nil {
+		return err
+	}
+	file := filepath.Join(dir, "index.html")
+	if err := ioutil.WriteFile(file, buf.Bytes(), 0644); err != nil {
+		return err
+	}
+	fmt.Printf("Wrote '%v'\n", file)
+	return nil
+}
+
+// writeContactSheets writes the thumbnails of items in sheets of columns x
+// rows into sheetDir, labeled with their date and camera.
+func writeContactSheets(dir string, sheetDir string, items []galleryItem, columns, rows int) error {
+	var thumbnails []galleryItem
+	for _, item := range items {
+		if item.Thumbnail != "" {
+			thumbnails = append(thumbnails, item)
+		}
+	}
+
+	perSheet := columns * rows
+	for sheet := 0; sheet*perSheet < len(thumbnails); sheet++ {
+		end := (sheet + 1) * perSheet
+		if end > len(thumbnails) {
+			end = len(thumbnails)
+		}
+
+		// montage -label LABEL thumb1.jpg -label LABEL thumb2.jpg ... -tile 6x -geometry +4+4 sheet.jpg
+		var args []string
+		for _, item := range thumbnails[sheet*perSheet : end] {
+			label := item.Info.Time.Format("2006-01-02 15:04")
+			if camera := strings.TrimSpace(item.Info.Make + " " + item.Info.Model); camera != "" {
+				label += "\n" + camera
+			}
+			// % is a format escape of montage labels
+			args = append(args, "-label", strings.Replace(label, "%", "%%", -1), item.Thumbnail)
+		}
+		sheetFile := filepath.Join(sheetDir, fmt.Sprintf("contact sheet %02d.jpg", sheet+1))
+		args = append(args, "-tile", fmt.Sprintf("%dx", columns), "-geometry", "+4+4", "-background", "white", sheetFile)
+
+		if err := os.MkdirAll(sheetDir, 0755); err != nil {
+			return err
+		}
+		montage := exec.Command("montage", args...)
+		if _, err := executil.RunWithVerboseError(montage); err != nil {
+			return err
+		}
+		fmt.Printf("Wrote '%v' of '%v'\n", sheetFile, dir)
+	}
+	return nil
+}
@@ -0,0 +1,303 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+const (
+	EARTH_RADIUS_KM    = 6371
+	MAX_CITY_DISTANCE  = 50 // km, photos farther from every city get no city
+	geocoderCellDegree = 1  // size of the cells of the geocoder index
+
+	defaultGeoNamesDir = ".newimage" // in the home dir, where the GeoNames files are looked for without --cities
+)
+
+var (
+	geocoder        *reverseGeocoder // nil when no cities file is given
+	exportLocations string           // file where the locations of the renamed files are written
+	locations       []imageInfo      // renamed files with a location, for exportLocations
+)
+
+// iso6709Regexp matches the location of QuickTime videos, e.g. '+38.7223-009.1393+045.000/'.
+var iso6709Regexp = regexp.MustCompile(`^([+-]\d+(?:\.\d+)?)([+-]\d+(?:\.\d+)?)`)
+
+// city is a place of the GeoNames cities file.
+type city struct {
+	Name        string
+	CountryCode string
+	Lat         float64
+	Long        float64
+}
+
+// reverseGeocoder finds the nearest city of a location, offline.
+type reverseGeocoder struct {
+	cells     map[[2]int][]city
+	countries map[string]string // country name by ISO code
+}
+
+// loadReverseGeocoder loads the GeoNames cities file citiesFile, e.g.
+// cities15000.txt, and the optional GeoNames countryInfo.txt countriesFile.
+func loadReverseGeocoder(citiesFile string, countriesFile string) (*reverseGeocoder, error) {
+	g := &reverseGeocoder{
+		cells:     make(map[[2]int][]city),
+		countries: make(map[string]string),
+	}
+
+	err := readTabFile(citiesFile, func(fields []string) {
+		// geonameid, name, asciiname, alternatenames, latitude, longitude, feature class, feature code, country code, ...
+		if len(fields) < 9 {
+			return
+		}
+		lat, err := strconv.ParseFloat(fields[4], 64)
+		if err != nil {
+			return
+		}
+		long, err := strconv.ParseFloat(fields[5], 64)
+		if err != nil {
+			return
+		}
+		c := city{Name: fields[1], CountryCode: fields[8], Lat: lat, Long: long}
+		cell := geocoderCell(lat, long)
+		g.cells[cell] = append(g.cells[cell], c)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(g.cells) == 0 {
+		return nil, fmt.Errorf("'%v' has no cities", citiesFile)
+	}
+
+	if countriesFile != "" {
+		err := readTabFile(countriesFile, func(fields []string) {
+			// ISO, ISO3, ISO-Numeric, fips, Country, ...
+			if len(fields) >= 5 {
+				g.countries[fields[0]] = fields[4]
+			}
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return g, nil
+}
+
+// defaultGeoNamesFile returns the path of the GeoNames file name in
+// defaultGeoNamesDir, or "" if it is not there.
+func defaultGeoNamesFile(name string) string {
+	home := os.Getenv("HOME")
+	if home == "" {
+		return ""
+	}
+	path := filepath.Join(home, defaultGeoNamesDir, name)
+	if _, err := os.Stat(path); err != nil {
+		return ""
+	}
+	return path
+}
+
+// readTabFile calls line with the fields of each line of the tab separated
+// file path, skipping comments.
+func readTabFile(path string, line func(fields []string)) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if text := scanner.Text(); text != "" && !strings.HasPrefix(text, "#") {
+			line(strings.Split(text, "\t"))
+		}
+	}
+	return scanner.Err()
+}
+
+func geocoderCell(lat, long float64) [2]int {
+	return [2]int{int(math.Floor(lat / geocoderCellDegree)), int(math.Floor(long / geocoderCellDegree))}
+}
+
+// nearestCity returns the city nearest to lat, long, if one is within
+// MAX_CITY_DISTANCE.
+func (g *reverseGeocoder) nearestCity(lat, long float64) (city, bool) {
+	var nearest city
+	best := math.Inf(1)
+
+	center := geocoderCell(lat, long)
+	// MAX_CITY_DISTANCE is less than a degree of latitude, longitude cells
+	// get narrower towards the poles
+	rings := 10
+	if cos := math.Cos(lat * math.Pi / 180); cos > 0.1 {
+		rings = int(math.Ceil(1 / cos))
+	}
+	for dLat := -1; dLat <= 1; dLat++ {
+		for dLong := -rings; dLong <= rings; dLong++ {
+			// wrapped at the antimeridian, where cells -180 and 179 are neighbours
+			cellLong := center[1] + dLong
+			cellLong = ((cellLong+180)%360+360)%360 - 180
+			cell := [2]int{center[0] + dLat, cellLong}
+			for _, c := range g.cells[cell] {
+				if d := distanceKm(lat, long, c.Lat, c.Long); d < best {
+					best = d
+					nearest = c
+				}
+			}
+		}
+	}
+	return nearest, best <= MAX_CITY_DISTANCE
+}
+
+// country returns the name of the country with code, or code when unknown.
+func (g *reverseGeocoder) country(code string) string {
+	if name, ok := g.countries[code]; ok {
+		return name
+	}
+	return code
+}
+
+// geocode fills the city and country of info from its location.
+func (g *reverseGeocoder) geocode(info *imageInfo) {
+	if !info.HasLocation {
+		return
+	}
+	if c, ok := g.nearestCity(info.Lat, info.Long); ok {
+		info.City = c.Name
+		info.Country = g.country(c.CountryCode)
+	}
+}
+
+// distanceKm returns the great circle distance between two locations.
+func distanceKm(lat1, long1, lat2, long2 float64) float64 {
+	toRad := math.Pi / 180
+	dLat := (lat2 - lat1) * toRad
+	dLong := (long2 - long1) * toRad
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1*toRad)*math.Cos(lat2*toRad)*math.Sin(dLong/2)*math.Sin(dLong/2)
+	return 2 * EARTH_RADIUS_KM * math.Asin(math.Min(1, math.Sqrt(a)))
+}
+
+// exifLocation returns the GPS location of x.
+func exifLocation(x *exif.Exif) (float64, float64, bool) {
+	lat, long, err := x.LatLong()
+	if err != nil || math.IsNaN(lat) || math.IsNaN(long) || (lat == 0 && long == 0) {
+		return 0, 0, false
+	}
+	return lat, long, true
+}
+
+// parseISO6709 returns the location of a QuickTime ISO 6709 value.
+func parseISO6709(value string) (float64, float64, bool) {
+	match := iso6709Regexp.FindStringSubmatch(value)
+	if match == nil {
+		return 0, 0, false
+	}
+	lat, err := strconv.ParseFloat(match[1], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	long, err := strconv.ParseFloat(match[2], 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	return lat, long, true
+}
+
+// addLocation adds the location of info, renamed to path, to the exported
+// locations.
+func addLocation(path string, info imageInfo) {
+	if !info.HasLocation {
+		return
+	}
+	info.Path = path
+	locations = append(locations, info)
+}
+
+// writeLocations writes infos to path as GeoJSON, if path ends in .geojson or
+// .json, or as CSV.
+func writeLocations(path string, infos []imageInfo) error {
+	sort.Slice(infos, func(i, j int) bool { return infos[i].Path < infos[j].Path })
+
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".geojson", ".json":
+		err = writeLocationsGeoJson(f, infos)
+	default:
+		err = writeLocationsCsv(f, infos)
+	}
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writeLocationsCsv(f *os.File, infos []imageInfo) error {
+	w := csv.NewWriter(f)
+	if err := w.Write([]string{"path", "date", "latitude", "longitude", "city", "country"}); err != nil {
+		return err
+	}
+	for _, info := range infos {
+		err := w.Write([]string{
+			info.Path,
+			info.Time.Format("2006-01-02T15:04:05-07:00"),
+			strconv.FormatFloat(info.Lat, 'f', 6, 64),
+			strconv.FormatFloat(info.Long, 'f', 6, 64),
+			info.City,
+			info.Country,
+		})
+		if err != nil {
+			return err
+		}
+	}
+	w.Flush()
+	return w.Error()
+}
+
+func writeLocationsGeoJson(f *os.File, infos []imageInfo) error {
+	type geometry struct {
+		Type        string    `json:"type"`
+		Coordinates []float64 `json:"coordinates"`
+	}
+	type feature struct {
+		Type       string            `json:"type"`
+		Geometry   geometry          `json:"geometry"`
+		Properties map[string]string `json:"properties"`
+	}
+	collection := struct {
+		Type     string    `json:"type"`
+		Features []feature `json:"features"`
+	}{Type: "FeatureCollection", Features: []feature{}}
+
+	for _, info := range infos {
+		collection.Features = append(collection.Features, feature{
+			Type:     "Feature",
+			Geometry: geometry{Type: "Point", Coordinates: []float64{info.Long, info.Lat}},
+			Properties: map[string]string{
+				"path":    info.Path,
+				"date":    info.Time.Format("2006-01-02T15:04:05-07:00"),
+				"city":    info.City,
+				"country": info.Country,
+			},
+		})
+	}
+
+	encoder := json.NewEncoder(f)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(collection)
+}
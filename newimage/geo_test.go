@@ -0,0 +1,38 @@
+package main
+
+import "testing"
+
+func TestNearestCity(t *testing.T) {
+	g := &reverseGeocoder{cells: make(map[[2]int][]city)}
+	for _, c := range []city{
+		{Name: "Lisbon", CountryCode: "PT", Lat: 38.7167, Long: -9.1333},
+		{Name: "Waiyevo", CountryCode: "FJ", Lat: -16.7833, Long: 179.9833},
+		{Name: "Rabi", CountryCode: "FJ", Lat: -16.5, Long: -179.97},
+		{Name: "Longyearbyen", CountryCode: "SJ", Lat: 78.2232, Long: 15.6469},
+	} {
+		cell := geocoderCell(c.Lat, c.Long)
+		g.cells[cell] = append(g.cells[cell], c)
+	}
+
+	tests := []struct {
+		name string
+		lat  float64
+		long float64
+		city string
+		ok   bool
+	}{
+		{"same cell", 38.72, -9.14, "Lisbon", true},
+		{"neighbour cell", 38.99, -8.9, "Lisbon", true},
+		{"too far", 40, -9.14, "", false},
+		{"across the antimeridian to the west", -16.8, -179.95, "Waiyevo", true},
+		{"across the antimeridian to the east", -16.5, 179.95, "Rabi", true},
+		{"far in longitude near the pole", 78.22, 16.9, "Longyearbyen", true},
+	}
+
+	for _, test := range tests {
+		c, ok := g.nearestCity(test.lat, test.long)
+		if ok != test.ok || (ok && c.Name != test.city) {
+			t.Errorf("%v: nearestCity(%v, %v) = %v, %v, want %v, %v", test.name, test.lat, test.long, c.Name, ok, test.city, test.ok)
+		}
+	}
+}
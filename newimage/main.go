@@ -2,6 +2,7 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"os"
@@ -9,34 +10,160 @@ import (
 	"strings"
 	"time"
 
+	"github.com/mateusbraga/tools/executil"
 	"github.com/rwcarlsen/goexif/exif"
 	"github.com/rwcarlsen/goexif/mknote"
 )
 
 var filetypesSupported = map[string]bool{
-	".jpg": true,
-	".mp4": true,
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+	".heic": true,
+	".heif": true,
+	".cr2":  true,
+	".nef":  true,
+	".arw":  true,
+	".dng":  true,
+	".mp4":  true,
+	".mov":  true,
+	".3gp":  true,
 }
 
-const (
-	timestampLayout = "2006-01-02 15.04.05"
-)
-
 var (
-	seqNumber = 1
+	renameTemplate = defaultNameTemplate
+	renameSeq      = 0 // number of files named in this run
+
+	pairedCommitted = make(map[string]bool) // paired files already renamed or copied along their raw file
 )
 
 func main() {
+	if len(os.Args) > 1 && os.Args[1] == "query" {
+		runQuery(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "gallery" {
+		runGallery(os.Args[2:])
+		return
+	}
+	if len(os.Args) > 1 && os.Args[1] == "strip" {
+		runStrip(os.Args[2:])
+		return
+	}
+
+	optimizeFlag := flag.Bool("optimize", false, "Losslessly recompress jpgs and pngs before renaming them")
+	keepMetadataFlag := flag.Bool("keep-metadata", true, "Keep exif and other metadata of optimized images")
+	webpFlag := flag.Bool("webp", false, "Convert pngs to lossless webp when optimizing")
+	maxResolutionFlag := flag.Int("max-resolution", 0, "Downsize optimized images whose largest side is above this number of pixels")
+	templateFlag := flag.String("template", defaultNameTemplate, "Template of the new names. Placeholders: {YYYY} {YY} {MM} {MonthName} {DD} {hh} {mm} {ss} {make} {model} {lens} {name} {seq} {ext} {event} {city} {country}. Files already named by a template with {name} or {seq} are not renamed again")
+	destFlag := flag.String("dest", "", "Sort the files into this directory, following --layout, instead of renaming them in place")
+	layoutFlag := flag.String("layout", defaultSortLayout, "Template of the directories inside --dest, e.g. '{YYYY}/{YYYY}-{MM}-{DD} {event}'")
+	copyFlag := flag.Bool("copy", false, "Copy the files into --dest instead of moving them")
+	eventFlag := flag.String("event", "", "Event name used by the {event} placeholder")
+	duplicatesFlag := flag.String("duplicates", "", "Find duplicates instead of renaming and report, hardlink, quarantine or delete them")
+	quarantineFlag := flag.String("quarantine", "", "Directory where --duplicates=quarantine moves duplicates to. It is not walked")
+	flag.Var(cameraOffsets, "camera-offset", "Clock offset of a camera model, e.g. 'Canon EOS 80D=-1h30m'. Can be repeated")
+	flag.Var(dirOffsets, "dir-offset", "Clock offset of the files in a directory, e.g. 'trip=+7h'. Can be repeated")
+	tzFlag := flag.String("tz", "", "Timezone of the new names, e.g. 'Europe/Lisbon'. Dates without timezone are taken as local")
+	clockPhotoFlag := flag.String("clock-photo", "", "Photo of a clock used to find the clock offset of its camera, with --clock-time")
+	clockTimeFlag := flag.String("clock-time", "", "Time shown by the clock in --clock-photo, e.g. '15:04:05'")
+	citiesFlag := flag.String("cities", "", "GeoNames cities file, e.g. cities15000.txt, used to find the {city} and {country} of photos offline (default: ~/"+defaultGeoNamesDir+"/cities15000.txt, if present)")
+	countriesFlag := flag.String("countries", "", "GeoNames countryInfo.txt file with the country names, country codes are used without it (default: ~/"+defaultGeoNamesDir+"/countryInfo.txt, if present)")
+	exportLocationsFlag := flag.String("export-locations", "", "Write the locations of the renamed files, or of every catalogued file with --catalog, to this .csv or .geojson file")
+	catalogFlag := flag.String("catalog", "", "Record the metadata of the files in this catalog and skip the files it has unchanged. Query it with 'newimage query --catalog FILE'")
+	dateSourcesFlag := flag.String("date-sources", defaultDateSources, "Where to read dates from, in order of priority")
+	noMtimeFlag := flag.Bool("no-mtime", false, "Do not rename files without a date other than their ModTime")
+	writeDatesFlag := flag.Bool("write-dates", false, "Write the date of the new names into the exif of jpgs, the atoms of videos and the ModTime of the files. Later runs must not apply the same offsets again")
+	similarityFlag := flag.Int("similarity", DEFAULT_SIMILARITY, "Maximum perceptual hash distance of similar photos, -1 to only find exact duplicates")
+	deleteSimilarFlag := flag.Bool("delete-similar", false, "Let --duplicates=quarantine and --duplicates=delete act on similar photos, not only on identical ones")
+	flag.Parse()
+
+	for _, template := range []string{*templateFlag, *layoutFlag} {
+		if err := checkTemplate(template); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	renameTemplate = *templateFlag
+	sortDest = *destFlag
+	sortLayout = *layoutFlag
+	sortCopy = *copyFlag
+	eventName = *eventFlag
+	writeDates = *writeDatesFlag
+
+	exportLocations = *exportLocationsFlag
+	if *catalogFlag != "" {
+		c, err := loadCatalog(*catalogFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		catalog = c
+	}
+	if *citiesFlag == "" {
+		*citiesFlag = defaultGeoNamesFile("cities15000.txt")
+	}
+	if *countriesFlag == "" {
+		*countriesFlag = defaultGeoNamesFile("countryInfo.txt")
+	}
+	if *citiesFlag != "" {
+		g, err := loadReverseGeocoder(*citiesFlag, *countriesFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		geocoder = g
+	}
+	if geocoder == nil {
+		templates := []string{renameTemplate}
+		if sortDest != "" {
+			templates = append(templates, sortLayout)
+		}
+		for _, template := range templates {
+			if usesLocationNames(template) {
+				log.Fatalf("{city} and {country} in '%v' need --cities, e.g. cities15000.txt from download.geonames.org/export/dump, or that file in ~/%v\n", template, defaultGeoNamesDir)
+			}
+		}
+	}
+
+	dateSources = nil
+	for _, source := range strings.Split(*dateSourcesFlag, ",") {
+		if source = strings.TrimSpace(source); source != "" && !(*noMtimeFlag && source == "mtime") {
+			dateSources = append(dateSources, source)
+		}
+	}
+	if err := checkDateSources(dateSources); err != nil {
+		log.Fatalln(err)
+	}
+
+	if *tzFlag != "" {
+		loc, err := time.LoadLocation(*tzFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		timezone = loc
+	}
+	if *clockPhotoFlag != "" {
+		model, offset, err := clockOffset(*clockPhotoFlag, *clockTimeFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("Clock of '%v' is off by %v\n", model, -offset)
+		if _, ok := cameraOffsets[model]; !ok {
+			cameraOffsets[model] = offset
+		}
+	}
+	if catalog != nil {
+		catalog.naming = namingFingerprint(*citiesFlag, *countriesFlag)
+	}
+
 	var rootDir string
 	var isRecursive bool
-	if len(os.Args) == 1 { // get rootDir from cmdline args or current dir
+	if len(flag.Args()) == 0 { // get rootDir from cmdline args or current dir
 		pwd, err := os.Getwd()
 		if err != nil {
 			log.Fatal(err)
 		}
 		rootDir = pwd
 	} else {
-		rootDir = os.Args[1]
+		rootDir = flag.Arg(0)
 		if rootDir == "./..." {
 			pwd, err := os.Getwd()
 			if err != nil {
@@ -47,14 +174,64 @@ func main() {
 		}
 	}
 
+	if *duplicatesFlag != "" {
+		if !duplicateActions[*duplicatesFlag] {
+			log.Fatalf("Unknown --duplicates action '%v'\n", *duplicatesFlag)
+		}
+		if *duplicatesFlag == "quarantine" && *quarantineFlag == "" {
+			log.Fatalln("--duplicates=quarantine needs --quarantine")
+		}
+
+		settings := duplicateSettings{
+			Action:        *duplicatesFlag,
+			QuarantineDir: *quarantineFlag,
+			Similarity:    *similarityFlag,
+			DeleteSimilar: *deleteSimilarFlag,
+		}
+		if err := findDuplicates(rootDir, isRecursive, settings); err != nil {
+			log.Fatalln(err)
+		}
+		return
+	}
+
 	done := make(chan struct{})
 	defer close(done)
 
+	filetypes := filetypesSupported
+	settings := optimizeSettings{
+		KeepMetadata:  *keepMetadataFlag,
+		Webp:          *webpFlag,
+		MaxResolution: *maxResolutionFlag,
+	}
+	if *optimizeFlag {
+		err := executil.HasExecutables(settings.executables()...)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		filetypes = make(map[string]bool)
+		for ext := range filetypesSupported {
+			filetypes[ext] = true
+		}
+		for ext := range optimizableFiletypes {
+			filetypes[ext] = true
+		}
+	}
+
 	// walkFiles will produce filenames in lexical order
-	paths, errc := walkFiles(done, rootDir, isRecursive)
+	paths, errc := walkFiles(done, rootDir, isRecursive, filetypes, "")
 
-	for path := range paths {
-		err := prepareImage(path)
+	// analyzeWorkers will consume them, optimize them if requested, read their
+	// metadata and produce them back in the same order
+	var optimize *optimizeSettings
+	if *optimizeFlag {
+		optimize = &settings
+	}
+	analyzed := startAnalyzeWorkers(done, paths, optimize)
+
+	// only this goroutine renames, so names are checked and taken in order
+	for file := range analyzed {
+		err := renameFile(file)
 		if err != nil {
 			fmt.Println(err)
 		}
@@ -63,109 +240,186 @@ func main() {
 	if err := <-errc; err != nil {
 		log.Fatalln(err)
 	}
-}
 
-func getExifDateTime(path string) (time.Time, error) {
-	f, err := os.Open(path)
-	if err != nil {
-		return time.Time{}, err
+	if *optimizeFlag {
+		printSavedBytes()
 	}
-	defer f.Close()
 
-	x, err := exif.Decode(f)
-	if err != nil {
-		return time.Time{}, fmt.Errorf("exif.Decode: %v", err)
+	if catalog != nil {
+		if err := catalog.save(); err != nil {
+			log.Fatalln(err)
+		}
 	}
 
-	tm, err := x.DateTime()
-	if err != nil {
-		return time.Time{}, err
+	if exportLocations != "" {
+		exported := locations
+		if catalog != nil {
+			// also the locations of the files of previous runs
+			exported = catalog.locations()
+		}
+		if err := writeLocations(exportLocations, exported); err != nil {
+			log.Fatalln(err)
+		}
+		fmt.Printf("Wrote %v locations to '%v'\n", len(exported), exportLocations)
 	}
-
-	return tm, nil
 }
 
-// newNameWithDate returns the name with the date from exif, if that does not exist it uses ModTime.
-func newNameWithDate(path string) (string, error) {
+// newNameWithDate returns the name built from renameTemplate with info, the metadata of path.
+// The name is in the directory of path, or in sortDest when sorting. When the
+// name is taken, for path or any of its paired files, the sub-second time of
+// the photo or a counter is appended to it.
+func newNameWithDate(path string, info imageInfo, paired []string) (string, error) {
 	ext := filepath.Ext(path)
-	dir := filepath.Dir(path)
 
-	tm, err := getExifDateTime(path)
-	if err != nil {
-		fstat, err := os.Stat(path)
+	renameSeq++
+	dir := targetDir(path, info, renameSeq)
+	if hasTemplateName(path, dir, renameTemplate, info) {
+		return path, nil
+	}
+	newName := expandTemplate(renameTemplate, info, renameSeq)
+	if !strings.Contains(renameTemplate, "{ext}") {
+		newName += ext
+	}
+	newExt := filepath.Ext(newName)
+	newBase := newName[:len(newName)-len(newExt)]
+
+	nextCandidate := collisionCandidates(dir, newBase, newExt, info.SubSec)
+	for {
+		newFile := nextCandidate()
+		if path == newFile {
+			// already has this name
+			return newFile, nil
+		}
+
+		_, err := os.Stat(newFile)
+		if err != nil && !os.IsNotExist(err) {
+			return "", err
+		}
+		if os.IsNotExist(err) {
+			taken, err := pairedNamesTaken(newFile, paired)
+			if err != nil {
+				return "", err
+			}
+			if !taken {
+				return newFile, nil
+			}
+			continue
+		}
+
+		same, err := sameContent(path, newFile)
 		if err != nil {
 			return "", err
 		}
-		tm = fstat.ModTime()
+		if same {
+			return "", duplicateError{Path: path, Existing: newFile}
+		}
 	}
+}
 
-	newFile := fmt.Sprintf("%s/%s%s", dir, tm.Format(timestampLayout), ext)
+// pairedName returns the name of the paired file of a file renamed to newFile.
+func pairedName(newFile string, pairedFile string) string {
+	return newFile[:len(newFile)-len(filepath.Ext(newFile))] + filepath.Ext(pairedFile)
+}
 
-	if path == newFile {
-		// skip
-		return newFile, nil
+// pairedNamesTaken returns true if the name of any of the paired files, when
+// renamed along a file renamed to newFile, already exists.
+func pairedNamesTaken(newFile string, paired []string) (bool, error) {
+	for _, pairedFile := range paired {
+		_, err := os.Stat(pairedName(newFile, pairedFile))
+		if err == nil {
+			return true, nil
+		}
+		if !os.IsNotExist(err) {
+			return false, err
+		}
 	}
+	return false, nil
+}
 
-	_, err = os.Stat(newFile)
-	if err == nil {
-		// already exists
-		newFile = fmt.Sprintf("%s/%s_%d%s", dir, tm.Format(timestampLayout), seqNumber, ext)
-		seqNumber++
+// renameFile renames an analyzed file, and its paired files, to the name
+// built from its metadata.
+func renameFile(file analyzedFile) error {
+	path, info, paired := file.Path, file.Info, file.Paired
+	if file.Skip || pairedCommitted[path] {
+		return nil
+	}
+	if file.Err != nil {
+		return file.Err
 	}
 
-	return newFile, nil
-}
+	newFile, err := newNameWithDate(path, info, paired)
+	if err != nil {
+		return err
+	}
 
-func prepareImage(path string) error {
-	switch ext := strings.ToLower(filepath.Ext(path)); ext {
-	case ".jpg":
-		newFile, err := newNameWithDate(path)
+	if path == newFile {
+		// skip
+		addLocation(newFile, info)
+		return finishFile(path, path, info)
+	}
 
-		if path == newFile {
-			// skip
-			return nil
-		}
+	if _, err := os.Stat(newFile); err == nil {
+		// don't overwrite anything
+		return fmt.Errorf("'%v' already exists, not overwriting it with '%v'", newFile, path)
+	}
 
-		_, err = os.Stat(newFile)
-		if err == nil {
-			// don't overwrite anything
-			return err
-		}
+	err = commitFile(path, newFile)
+	if err != nil {
+		return err
+	}
+	printCommit(path, newFile, info.DateSource)
+	addLocation(newFile, info)
+	if err := finishFile(path, newFile, info); err != nil {
+		return err
+	}
 
-		err = os.Rename(path, newFile)
-		if err != nil {
+	for _, pairedFile := range paired {
+		newPairedFile := pairedName(newFile, pairedFile)
+		if err := commitFile(pairedFile, newPairedFile); err != nil {
 			return err
 		}
+		printCommit(pairedFile, newPairedFile, info.DateSource)
+		pairedCommitted[pairedFile] = true
 
-		fmt.Printf("%v -> %v\n", filepath.Base(path), filepath.Base(newFile))
-		return nil
-	case ".mp4":
-		newFile, err := newNameWithDate(path)
-
-		if path == newFile {
-			// skip
-			return nil
-		}
-
-		_, err = os.Stat(newFile)
-		if err == nil {
-			// don't overwrite anything
+		if err := finishFile(pairedFile, newPairedFile, info); err != nil {
 			return err
 		}
+	}
+	return nil
+}
 
-		err = os.Rename(path, newFile)
-		if err != nil {
+// finishFile writes the dates into newFile, renamed from path, and records it
+// in the catalog, as requested.
+func finishFile(path string, newFile string, info imageInfo) error {
+	if writeDates {
+		if err := writeFileDates(newFile, info.Time); err != nil {
 			return err
 		}
+	}
+	if catalog != nil {
+		return catalog.record(path, newFile, info)
+	}
+	return nil
+}
 
-		fmt.Printf("%v -> %v\n", filepath.Base(path), filepath.Base(newFile))
-		return nil
-	default:
-		return fmt.Errorf("'%v' filetype '%v' is not supported", path, ext)
+// printCommit prints the rename of path to newFile, with the source of the
+// date of the new name.
+func printCommit(path string, newFile string, dateSource string) {
+	if sortDest == "" {
+		fmt.Printf("%v -> %v (%v)\n", filepath.Base(path), filepath.Base(newFile), dateSource)
+		return
+	}
+
+	rel, err := filepath.Rel(sortDest, newFile)
+	if err != nil {
+		rel = newFile
 	}
+	fmt.Printf("%v -> %v (%v)\n", filepath.Base(path), rel, dateSource)
 }
 
-func walkFiles(done <-chan struct{}, root string, isRecursive bool) (<-chan string, <-chan error) {
+// walkFiles produces the absolute paths of the files of filetypes in root, and
+// in its subdirs if isRecursive, except the ones in excludeDir.
+func walkFiles(done <-chan struct{}, root string, isRecursive bool, filetypes map[string]bool, excludeDir string) (<-chan string, <-chan error) {
 	paths := make(chan string)
 	errc := make(chan error, 1)
 
@@ -173,11 +427,21 @@ func walkFiles(done <-chan struct{}, root string, isRecursive bool) (<-chan stri
 		defer close(paths)
 		errc <- filepath.Walk(root, func(path string, info os.FileInfo, err error) error {
 			if err != nil {
+				if os.IsNotExist(err) {
+					// renamed along its paired raw file
+					return nil
+				}
 				fmt.Println(err)
 				return nil
 			}
 
 			if info.IsDir() {
+				if excludeDir != "" {
+					if abs, _ := filepath.Abs(path); abs == excludeDir {
+						// e.g. the thumbnails of the gallery
+						return filepath.SkipDir
+					}
+				}
 				if isRecursive || path == root {
 					fmt.Printf("Walk in '%v'\n", path)
 					return nil
@@ -186,7 +450,7 @@ func walkFiles(done <-chan struct{}, root string, isRecursive bool) (<-chan stri
 				}
 			}
 
-			if ext := filepath.Ext(path); filetypesSupported[strings.ToLower(ext)] {
+			if ext := filepath.Ext(path); filetypes[strings.ToLower(ext)] {
 				abs, _ := filepath.Abs(path)
 				select {
 				case paths <- abs:
@@ -202,4 +466,5 @@ func walkFiles(done <-chan struct{}, root string, isRecursive bool) (<-chan stri
 
 func init() {
 	exif.RegisterParsers(mknote.All...)
+	exif.RegisterParsers(offsetTimeParser{})
 }
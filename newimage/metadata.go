@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+// imageInfo holds the metadata of an image or video used to name it.
+type imageInfo struct {
+	Path  string
+	Time  time.Time
+	Make  string
+	Model string
+	Lens  string
+
+	SubSec     string // sub-second digits of Time, "" if unknown
+	DateSource string // where Time was read from, e.g. DateTimeOriginal
+
+	HasLocation bool
+	Lat         float64
+	Long        float64
+	City        string // nearest city, when geocoder is set
+	Country     string
+}
+
+// readImageInfo reads the metadata of path, with its date corrected by
+// correctTime and its city found by geocoder.
+func readImageInfo(path string) (imageInfo, error) {
+	info, err := readUncorrectedImageInfo(path)
+	if err != nil {
+		return info, err
+	}
+	info.Time = correctTime(info)
+	if geocoder != nil {
+		geocoder.geocode(&info)
+	}
+	return info, nil
+}
+
+// readUncorrectedImageInfo reads the metadata of path. The date comes from the
+// first of dateSources path has.
+func readUncorrectedImageInfo(path string) (imageInfo, error) {
+	info := imageInfo{Path: path}
+
+	m := fileMetadata{Path: path}
+	if videoFiletypes[strings.ToLower(filepath.Ext(path))] {
+		if video, err := readVideoMetadata(path); err == nil {
+			m.Video = &video
+			info.Make = video.Make
+			info.Model = video.Model
+			info.Lat, info.Long, info.HasLocation = video.Lat, video.Long, video.HasLocation
+		}
+	} else if x, err := decodeExif(path); err == nil {
+		m.Exif = x
+		info.Make = exifString(x, exif.Make)
+		info.Model = exifString(x, exif.Model)
+		info.Lens = exifString(x, exif.LensModel)
+		info.Lat, info.Long, info.HasLocation = exifLocation(x)
+	}
+
+	for _, source := range dateSources {
+		date, ok := dateSourceReaders[source](m)
+		if !ok {
+			continue
+		}
+		info.Time = date.Time
+		info.SubSec = date.SubSec
+		info.DateSource = date.Source
+		return info, nil
+	}
+
+	return info, fmt.Errorf("'%v' has no date in %v", path, strings.Join(dateSources, ","))
+}
+
+func decodeExif(path string) (*exif.Exif, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := exifData(f)
+	if err != nil {
+		return nil, fmt.Errorf("'%v': %v", path, err)
+	}
+
+	x, err := exif.Decode(data)
+	if err != nil {
+		return nil, fmt.Errorf("exif.Decode: %v", err)
+	}
+	return x, nil
+}
+
+// exifString returns the trimmed string value of field, or "" if it is not present.
+func exifString(x *exif.Exif, field exif.FieldName) string {
+	tag, err := x.Get(field)
+	if err != nil {
+		return ""
+	}
+	value, err := tag.StringVal()
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(strings.Trim(value, "\x00"))
+}
+
+// subSecond returns the sub-second digits in field, e.g. "045" for 0.045s,
+// which tell apart the photos of a burst taken in the same second.
+func subSecond(x *exif.Exif, field exif.FieldName) string {
+	value := exifString(x, field)
+	if _, err := strconv.Atoi(value); err != nil {
+		return ""
+	}
+	return value
+}
+
+// subSecondNanoseconds converts the digits of a sub-second value to nanoseconds.
+func subSecondNanoseconds(subSec string) (int, bool) {
+	if subSec == "" || len(subSec) > 9 {
+		return 0, false
+	}
+	nsec, err := strconv.Atoi(subSec + strings.Repeat("0", 9-len(subSec)))
+	if err != nil {
+		return 0, false
+	}
+	return nsec, true
+}
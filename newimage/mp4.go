@@ -0,0 +1,301 @@
+package main
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+const (
+	MAX_ATOM_SIZE = 64 << 20 // moov and meta atoms above it are not read
+)
+
+var videoFiletypes = map[string]bool{
+	".mp4": true,
+	".mov": true,
+	".3gp": true,
+}
+
+// mp4Epoch is the origin of the times in mvhd and tkhd atoms.
+var mp4Epoch = time.Date(1904, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// quickTimeDateLayouts are the layouts seen in com.apple.quicktime.creationdate.
+var quickTimeDateLayouts = []string{
+	"2006-01-02T15:04:05-0700",
+	"2006-01-02T15:04:05Z07:00",
+	"2006-01-02T15:04:05",
+}
+
+// atom is a box of an MP4/QuickTime file.
+type atom struct {
+	Type string
+	Data []byte // payload, without the header
+}
+
+// videoMetadata is what newimage uses of the metadata of a video.
+type videoMetadata struct {
+	Time  time.Time
+	Make  string
+	Model string
+
+	HasLocation bool
+	Lat         float64
+	Long        float64
+}
+
+// readVideoMetadata reads the recording date of an MP4, MOV or 3GP video.
+//
+// The QuickTime creationdate key has the wall clock and the timezone of the
+// recording, and is preferred. The mvhd and tkhd creation times are in UTC and
+// are converted to the local timezone.
+func readVideoMetadata(path string) (videoMetadata, error) {
+	var metadata videoMetadata
+
+	f, err := os.Open(path)
+	if err != nil {
+		return metadata, err
+	}
+	defer f.Close()
+
+	moov, err := readTopLevelAtom(f, "moov")
+	if err != nil {
+		return metadata, fmt.Errorf("'%v': %v", path, err)
+	}
+
+	keys := quickTimeKeys(moov)
+	metadata.Make = keys["com.apple.quicktime.make"]
+	metadata.Model = keys["com.apple.quicktime.model"]
+	metadata.Lat, metadata.Long, metadata.HasLocation = parseISO6709(keys["com.apple.quicktime.location.ISO6709"])
+	if t, ok := parseQuickTimeDate(keys["com.apple.quicktime.creationdate"]); ok {
+		metadata.Time = t
+		return metadata, nil
+	}
+
+	if t, ok := headerCreationTime(findAtom(moov, "mvhd")); ok {
+		metadata.Time = t.In(time.Local)
+		return metadata, nil
+	}
+	for _, trak := range findAtoms(moov, "trak") {
+		if t, ok := headerCreationTime(findAtom(trak, "tkhd")); ok {
+			metadata.Time = t.In(time.Local)
+			return metadata, nil
+		}
+	}
+
+	return metadata, fmt.Errorf("'%v' has no creation time", path)
+}
+
+// readTopLevelAtom returns the payload of the first top level atom of f with type typ.
+func readTopLevelAtom(f *os.File, typ string) ([]byte, error) {
+	_, data, err := locateTopLevelAtom(f, typ)
+	return data, err
+}
+
+// locateTopLevelAtom returns the offset in f and the payload of the first top
+// level atom of f with type typ.
+func locateTopLevelAtom(f *os.File, typ string) (int64, []byte, error) {
+	fstat, err := f.Stat()
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var offset int64
+	header := make([]byte, 16)
+	for offset+8 <= fstat.Size() {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return 0, nil, err
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		atomType := string(header[4:8])
+		headerSize := int64(8)
+
+		switch size {
+		case 0: // until the end of the file
+			size = fstat.Size() - offset
+		case 1: // 64 bit size after the type
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return 0, nil, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+			headerSize = 16
+		}
+		if size < headerSize || offset+size > fstat.Size() {
+			return 0, nil, fmt.Errorf("invalid atom '%v' at %v", atomType, offset)
+		}
+
+		if atomType == typ {
+			if size-headerSize > MAX_ATOM_SIZE {
+				return 0, nil, fmt.Errorf("%v atom of %v bytes is too big", typ, size)
+			}
+			data := make([]byte, size-headerSize)
+			if _, err := f.ReadAt(data, offset+headerSize); err != nil && err != io.EOF {
+				return 0, nil, err
+			}
+			return offset + headerSize, data, nil
+		}
+		offset += size
+	}
+	return 0, nil, fmt.Errorf("%v atom not found", typ)
+}
+
+// parseAtoms splits data into the atoms it contains.
+func parseAtoms(data []byte) []atom {
+	var atoms []atom
+	for len(data) >= 8 {
+		size := uint64(binary.BigEndian.Uint32(data[:4]))
+		typ := string(data[4:8])
+		headerSize := uint64(8)
+		switch size {
+		case 0:
+			size = uint64(len(data))
+		case 1:
+			if len(data) < 16 {
+				return atoms
+			}
+			size = binary.BigEndian.Uint64(data[8:16])
+			headerSize = 16
+		}
+		if size < headerSize || size > uint64(len(data)) {
+			return atoms
+		}
+
+		atoms = append(atoms, atom{Type: typ, Data: data[headerSize:size]})
+		data = data[size:]
+	}
+	return atoms
+}
+
+// findAtoms returns the children of the container data with type typ.
+func findAtoms(data []byte, typ string) [][]byte {
+	var found [][]byte
+	for _, a := range parseAtoms(data) {
+		if a.Type == typ {
+			found = append(found, a.Data)
+		}
+	}
+	return found
+}
+
+// findAtom returns the first child of data with type typ, or nil.
+func findAtom(data []byte, typ string) []byte {
+	found := findAtoms(data, typ)
+	if len(found) == 0 {
+		return nil
+	}
+	return found[0]
+}
+
+// headerCreationTime returns the creation time of an mvhd or tkhd payload.
+func headerCreationTime(data []byte) (time.Time, bool) {
+	if len(data) < 4 {
+		return time.Time{}, false
+	}
+
+	var seconds uint64
+	switch version := data[0]; version {
+	case 0:
+		if len(data) < 8 {
+			return time.Time{}, false
+		}
+		seconds = uint64(binary.BigEndian.Uint32(data[4:8]))
+	case 1:
+		if len(data) < 12 {
+			return time.Time{}, false
+		}
+		seconds = binary.BigEndian.Uint64(data[4:12])
+	default:
+		return time.Time{}, false
+	}
+
+	if seconds == 0 {
+		// not set by the camera
+		return time.Time{}, false
+	}
+	return mp4Epoch.Add(time.Duration(seconds) * time.Second), true
+}
+
+// quickTimeKeys returns the string values of the QuickTime metadata, from
+// moov/meta or moov/udta/meta, by key.
+func quickTimeKeys(moov []byte) map[string]string {
+	values := make(map[string]string)
+	for key, value := range quickTimeItems(moov) {
+		values[key] = strings.TrimSpace(strings.Trim(string(value), "\x00"))
+	}
+	return values
+}
+
+// quickTimeItems returns the string values of the QuickTime metadata by key,
+// as slices of moov.
+func quickTimeItems(moov []byte) map[string][]byte {
+	values := make(map[string][]byte)
+
+	metas := findAtoms(moov, "meta")
+	for _, udta := range findAtoms(moov, "udta") {
+		metas = append(metas, findAtoms(udta, "meta")...)
+	}
+
+	for _, meta := range metas {
+		keys := parseKeys(findAtom(meta, "keys"))
+		for _, item := range parseAtoms(findAtom(meta, "ilst")) {
+			index := int(binary.BigEndian.Uint32([]byte(item.Type)))
+			if index < 1 || index > len(keys) {
+				continue
+			}
+			if value, ok := stringData(item.Data); ok {
+				values[keys[index-1]] = value
+			}
+		}
+	}
+	return values
+}
+
+// parseKeys returns the keys of a keys atom, in order.
+func parseKeys(data []byte) []string {
+	if len(data) < 8 {
+		return nil
+	}
+	count := binary.BigEndian.Uint32(data[4:8])
+	data = data[8:]
+
+	var keys []string
+	for i := uint32(0); i < count && len(data) >= 8; i++ {
+		size := binary.BigEndian.Uint32(data[:4])
+		if size < 8 || int(size) > len(data) {
+			break
+		}
+		// data[4:8] is the namespace, usually mdta
+		keys = append(keys, string(data[8:size]))
+		data = data[size:]
+	}
+	return keys
+}
+
+// stringData returns the value of the data atom inside an ilst item if it is
+// a string.
+func stringData(item []byte) ([]byte, bool) {
+	data := findAtom(item, "data")
+	if len(data) < 8 {
+		return nil, false
+	}
+	// 1 is UTF-8, 2 is UTF-16
+	if dataType := binary.BigEndian.Uint32(data[:4]) & 0xffffff; dataType != 1 {
+		return nil, false
+	}
+	// data[4:8] is the locale
+	return data[8:], true
+}
+
+func parseQuickTimeDate(value string) (time.Time, bool) {
+	if value == "" {
+		return time.Time{}, false
+	}
+	for _, layout := range quickTimeDateLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, true
+		}
+	}
+	return time.Time{}, false
+}
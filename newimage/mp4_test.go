@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testAtom returns an atom of typ with the concatenation of payloads.
+func testAtom(typ string, payloads ...[]byte) []byte {
+	payload := bytes.Join(payloads, nil)
+	data := make([]byte, 8, 8+len(payload))
+	binary.BigEndian.PutUint32(data, uint32(8+len(payload)))
+	copy(data[4:], typ)
+	return append(data, payload...)
+}
+
+// testHeader returns a version 0 mvhd or tkhd payload created at t.
+func testHeader(t time.Time) []byte {
+	data := make([]byte, 100)
+	binary.BigEndian.PutUint32(data[4:8], uint32(t.Sub(mp4Epoch)/time.Second))
+	return data
+}
+
+// testQuickTimeMeta returns a meta atom with the string values by key.
+func testQuickTimeMeta(keys []string, values map[string]string) []byte {
+	keysPayload := make([]byte, 8)
+	binary.BigEndian.PutUint32(keysPayload[4:], uint32(len(keys)))
+	var items [][]byte
+	for i, key := range keys {
+		entry := make([]byte, 8)
+		binary.BigEndian.PutUint32(entry, uint32(8+len(key)))
+		copy(entry[4:], "mdta")
+		keysPayload = append(keysPayload, append(entry, key...)...)
+
+		data := make([]byte, 8)
+		binary.BigEndian.PutUint32(data, 1) // UTF-8
+		index := make([]byte, 4)
+		binary.BigEndian.PutUint32(index, uint32(i+1))
+		items = append(items, testAtom(string(index), testAtom("data", data, []byte(values[key]))))
+	}
+	return testAtom("meta", testAtom("keys", keysPayload), testAtom("ilst", items...))
+}
+
+func TestParseAtoms(t *testing.T) {
+	largeSize := func(size byte) []byte {
+		return []byte{0, 0, 0, 1, 'f', 'r', 'e', 'e', 0, 0, 0, 0, 0, 0, 0, size, 'x', 'y'}
+	}
+
+	tests := []struct {
+		name  string
+		data  []byte
+		atoms []atom
+	}{
+		{"empty", nil, nil},
+		{"one", testAtom("ftyp", []byte("isom")), []atom{{"ftyp", []byte("isom")}}},
+		{"two", append(testAtom("ftyp"), testAtom("free", []byte{1})...), []atom{{"ftyp", []byte{}}, {"free", []byte{1}}}},
+		{"until the end", []byte{0, 0, 0, 0, 'm', 'd', 'a', 't', 1, 2}, []atom{{"mdat", []byte{1, 2}}}},
+		{"64 bit size", largeSize(18), []atom{{"free", []byte("xy")}}},
+		{"64 bit size truncated", largeSize(18)[:12], nil},
+		{"64 bit size too big", largeSize(19), nil},
+		{"64 bit size smaller than header", largeSize(12), nil},
+		{"size smaller than header", []byte{0, 0, 0, 4, 'f', 'r', 'e', 'e'}, nil},
+		{"size past the end", append(testAtom("ftyp"), 0, 0, 0, 9, 'f', 'r', 'e', 'e'), []atom{{"ftyp", []byte{}}}},
+		{"short header", []byte{0, 0, 0, 8, 'f'}, nil},
+	}
+
+	for _, test := range tests {
+		atoms := parseAtoms(test.data)
+		if !reflect.DeepEqual(atoms, test.atoms) {
+			t.Errorf("%v: parseAtoms() = %q, want %q", test.name, atoms, test.atoms)
+		}
+	}
+}
+
+func TestHeaderCreationTime(t *testing.T) {
+	created := time.Date(2019, time.May, 4, 13, 14, 15, 0, time.UTC)
+	version1 := make([]byte, 112)
+	version1[0] = 1
+	binary.BigEndian.PutUint64(version1[4:12], uint64(created.Sub(mp4Epoch)/time.Second))
+
+	tests := []struct {
+		name string
+		data []byte
+		time time.Time
+		ok   bool
+	}{
+		{"version 0", testHeader(created), created, true},
+		{"version 1", version1, created, true},
+		{"not set", make([]byte, 100), time.Time{}, false},
+		{"unknown version", append([]byte{2}, testHeader(created)[1:]...), time.Time{}, false},
+		{"short version 0", testHeader(created)[:6], time.Time{}, false},
+		{"short version 1", version1[:10], time.Time{}, false},
+		{"empty", nil, time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		created, ok := headerCreationTime(test.data)
+		if ok != test.ok || !created.Equal(test.time) {
+			t.Errorf("%v: headerCreationTime() = %v, %v, want %v, %v", test.name, created, ok, test.time, test.ok)
+		}
+	}
+}
+
+func TestQuickTimeKeys(t *testing.T) {
+	keys := []string{"com.apple.quicktime.make", "com.apple.quicktime.creationdate"}
+	values := map[string]string{
+		"com.apple.quicktime.make":         "Apple\x00",
+		"com.apple.quicktime.creationdate": "2019-05-04T15:14:15+0200",
+	}
+	want := map[string]string{
+		"com.apple.quicktime.make":         "Apple",
+		"com.apple.quicktime.creationdate": "2019-05-04T15:14:15+0200",
+	}
+
+	for name, moov := range map[string][]byte{
+		"meta":      testQuickTimeMeta(keys, values),
+		"udta/meta": testAtom("udta", testQuickTimeMeta(keys, values)),
+	} {
+		if got := quickTimeKeys(moov); !reflect.DeepEqual(got, want) {
+			t.Errorf("%v: quickTimeKeys() = %q, want %q", name, got, want)
+		}
+	}
+
+	// an item index past the keys is ignored
+	moov := testQuickTimeMeta(keys, values)
+	count := bytes.Index(moov, []byte("keys")) + 8
+	binary.BigEndian.PutUint32(moov[count:], 1)
+	want = map[string]string{"com.apple.quicktime.make": "Apple"}
+	if got := quickTimeKeys(moov); !reflect.DeepEqual(got, want) {
+		t.Errorf("quickTimeKeys() with an index past the keys = %q, want %q", got, want)
+	}
+}
+
+func TestParseQuickTimeDate(t *testing.T) {
+	tests := []struct {
+		value string
+		time  time.Time
+		ok    bool
+	}{
+		{"2019-05-04T15:14:15+0200", time.Date(2019, time.May, 4, 13, 14, 15, 0, time.UTC), true},
+		{"2019-05-04T15:14:15+02:00", time.Date(2019, time.May, 4, 13, 14, 15, 0, time.UTC), true},
+		{"2019-05-04T15:14:15Z", time.Date(2019, time.May, 4, 15, 14, 15, 0, time.UTC), true},
+		{"2019-05-04T15:14:15", time.Date(2019, time.May, 4, 15, 14, 15, 0, time.UTC), true},
+		{"", time.Time{}, false},
+		{"May 4 2019", time.Time{}, false},
+	}
+
+	for _, test := range tests {
+		parsed, ok := parseQuickTimeDate(test.value)
+		if ok != test.ok || !parsed.Equal(test.time) {
+			t.Errorf("parseQuickTimeDate(%q) = %v, %v, want %v, %v", test.value, parsed, ok, test.time, test.ok)
+		}
+	}
+}
+
+func TestReadVideoMetadata(t *testing.T) {
+	dir, err := ioutil.TempDir("", "newimage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	created := time.Date(2019, time.May, 4, 13, 14, 15, 0, time.UTC)
+	quickTime := testQuickTimeMeta([]string{"com.apple.quicktime.creationdate"}, map[string]string{
+		"com.apple.quicktime.creationdate": "2019-05-04T15:14:15+0200",
+	})
+
+	tests := []struct {
+		name string
+		data []byte
+		time time.Time
+		err  bool
+	}{
+		{"mvhd", append(testAtom("ftyp", []byte("isom")), testAtom("moov", testAtom("mvhd", testHeader(created)))...), created, false},
+		{"tkhd", testAtom("moov", testAtom("mvhd", make([]byte, 100)), testAtom("trak", testAtom("tkhd", testHeader(created)))), created, false},
+		{"creationdate first", testAtom("moov", testAtom("mvhd", testHeader(created.Add(time.Hour))), quickTime), created, false},
+		{"no time", testAtom("moov", testAtom("mvhd", make([]byte, 100))), time.Time{}, true},
+		{"no moov", testAtom("ftyp", []byte("isom")), time.Time{}, true},
+		{"truncated", testAtom("moov", testAtom("mvhd", testHeader(created)))[:50], time.Time{}, true},
+	}
+
+	for i, test := range tests {
+		path := filepath.Join(dir, string(rune('a'+i))+".mp4")
+		if err := ioutil.WriteFile(path, test.data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		metadata, err := readVideoMetadata(path)
+		if (err != nil) != test.err {
+			t.Errorf("%v: readVideoMetadata() error = %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if !metadata.Time.Equal(test.time) {
+			t.Errorf("%v: readVideoMetadata() time = %v, want %v", test.name, metadata.Time, test.time)
+		}
+	}
+}
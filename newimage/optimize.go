@@ -0,0 +1,275 @@
+package main
+
+import (
+	"fmt"
+	"image"
+	_ "image/jpeg"
+	_ "image/png"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/mateusbraga/tools/executil"
+	"github.com/rwcarlsen/goexif/exif"
+)
+
+var optimizableFiletypes = map[string]bool{
+	".jpg":  true,
+	".jpeg": true,
+	".png":  true,
+}
+
+var (
+	savedBytesMutex sync.Mutex
+	savedBytes      = make(map[string]int64) // bytes saved per directory
+)
+
+// optimizeSettings configures the optimization pass.
+type optimizeSettings struct {
+	KeepMetadata  bool
+	Webp          bool // convert pngs to lossless webp instead of optimizing them as png
+	MaxResolution int  // images with a larger side above it are downsized, 0 to never downsize
+}
+
+func (s optimizeSettings) executables() []string {
+	executables := []string{"jpegtran"}
+	if s.Webp {
+		executables = append(executables, "cwebp")
+	} else {
+		executables = append(executables, "optipng")
+	}
+	if s.MaxResolution > 0 {
+		executables = append(executables, "convert")
+	}
+	return executables
+}
+
+// optimizeImage downsizes path if needed and recompresses it. It returns the
+// path of the optimized image, which changes when a png is converted to webp.
+func optimizeImage(path string, settings optimizeSettings) (string, error) {
+	fstat, err := os.Stat(path)
+	if err != nil {
+		return path, err
+	}
+	originalSize := fstat.Size()
+	modTime := fstat.ModTime()
+
+	if settings.MaxResolution > 0 {
+		if err := downsizeImage(path, settings.MaxResolution); err != nil {
+			return path, err
+		}
+	}
+
+	newPath := path
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".jpg", ".jpeg":
+		err = optimizeJpeg(path, settings.KeepMetadata)
+	case ".png":
+		if settings.Webp {
+			newPath, err = convertToWebp(path, settings.KeepMetadata)
+		} else {
+			err = optimizePng(path, settings.KeepMetadata)
+		}
+	default:
+		err = fmt.Errorf("'%v' filetype '%v' can not be optimized", path, ext)
+	}
+	if err != nil {
+		return path, err
+	}
+
+	// the ModTime may be the only date of the image, e.g. of screenshots
+	if err := os.Chtimes(newPath, modTime, modTime); err != nil {
+		return newPath, err
+	}
+	fstat, err = os.Stat(newPath)
+	if err != nil {
+		return newPath, err
+	}
+
+	saved := originalSize - fstat.Size()
+	savedBytesMutex.Lock()
+	savedBytes[filepath.Dir(path)] += saved
+	savedBytesMutex.Unlock()
+
+	if saved > 0 {
+		fmt.Printf("Optimized %v (-%v bytes)\n", filepath.Base(newPath), saved)
+	}
+	return newPath, nil
+}
+
+// downsizeImage resizes path, keeping its aspect ratio, if its largest side is
+// above maxResolution pixels.
+func downsizeImage(path string, maxResolution int) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	config, _, err := image.DecodeConfig(f)
+	f.Close()
+	if err != nil {
+		return fmt.Errorf("image.DecodeConfig '%v': %v", path, err)
+	}
+
+	if config.Width <= maxResolution && config.Height <= maxResolution {
+		return nil
+	}
+
+	tempFile := path + ".resized" + filepath.Ext(path)
+	geometry := fmt.Sprintf("%dx%d>", maxResolution, maxResolution)
+	convert := exec.Command("convert", path, "-resize", geometry, tempFile)
+	if _, err := executil.RunWithVerboseError(convert); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+
+	log.Printf("Downsized '%v' from %vx%v", path, config.Width, config.Height)
+	return os.Rename(tempFile, path)
+}
+
+func optimizeJpeg(path string, keepMetadata bool) error {
+	copyArg := "none"
+	if keepMetadata {
+		copyArg = "all"
+	}
+
+	// the orientation is stripped with the rest of the metadata, so it is
+	// applied to the pixels instead
+	source := path
+	orientation := 1
+	if !keepMetadata {
+		orientation = jpegOrientation(path)
+	}
+	if jpegtranOrientations[orientation] != nil {
+		oriented, err := orientJpeg(path, orientation)
+		if err != nil {
+			return err
+		}
+		source = path + ".rotated.jpg"
+		if err := ioutil.WriteFile(source, oriented, 0644); err != nil {
+			os.Remove(source)
+			return err
+		}
+		defer os.Remove(source)
+	}
+
+	// jpegtran -copy all -optimize -progressive -outfile output.jpg input.jpg
+	tempFile := path + ".optimized.jpg"
+	jpegtran := exec.Command("jpegtran", "-copy", copyArg, "-optimize", "-progressive", "-outfile", tempFile, source)
+	if _, err := executil.RunWithVerboseError(jpegtran); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+
+	return replaceIfSmaller(tempFile, path)
+}
+
+// jpegOrientation returns the exif orientation of the jpg in path, 1 if unknown.
+func jpegOrientation(path string) int {
+	x, err := decodeExif(path)
+	if err != nil {
+		return 1
+	}
+	tag, err := x.Get(exif.Orientation)
+	if err != nil {
+		return 1
+	}
+	orientation, err := tag.Int(0)
+	if err != nil {
+		return 1
+	}
+	return orientation
+}
+
+func optimizePng(path string, keepMetadata bool) error {
+	// optipng -o2 -strip all -out output.png input.png
+	tempFile := path + ".optimized.png"
+	args := []string{"-quiet", "-o2"}
+	if !keepMetadata {
+		args = append(args, "-strip", "all")
+	}
+	args = append(args, "-out", tempFile, path)
+
+	optipng := exec.Command("optipng", args...)
+	if _, err := executil.RunWithVerboseError(optipng); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+
+	return replaceIfSmaller(tempFile, path)
+}
+
+// convertToWebp converts the png in path to a lossless webp, which replaces it
+// if smaller. It returns the path of the resulting image.
+func convertToWebp(path string, keepMetadata bool) (string, error) {
+	metadataArg := "none"
+	if keepMetadata {
+		metadataArg = "all"
+	}
+
+	// cwebp -quiet -lossless -metadata all input.png -o output.webp
+	newPath := path[:len(path)-len(filepath.Ext(path))] + ".webp"
+	if _, err := os.Stat(newPath); err == nil {
+		return path, fmt.Errorf("'%v' already exists", newPath)
+	}
+
+	cwebp := exec.Command("cwebp", "-quiet", "-lossless", "-metadata", metadataArg, path, "-o", newPath)
+	if _, err := executil.RunWithVerboseError(cwebp); err != nil {
+		os.Remove(newPath)
+		return path, err
+	}
+
+	pngStat, err := os.Stat(path)
+	if err != nil {
+		return path, err
+	}
+	webpStat, err := os.Stat(newPath)
+	if err != nil {
+		return path, err
+	}
+	if webpStat.Size() >= pngStat.Size() {
+		os.Remove(newPath)
+		return path, nil
+	}
+
+	return newPath, os.Remove(path)
+}
+
+// replaceIfSmaller replaces path with newFile if newFile is smaller. newFile is removed otherwise.
+func replaceIfSmaller(newFile string, path string) error {
+	newStat, err := os.Stat(newFile)
+	if err != nil {
+		return err
+	}
+	oldStat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+
+	if newStat.Size() >= oldStat.Size() {
+		return os.Remove(newFile)
+	}
+	return os.Rename(newFile, path)
+}
+
+func printSavedBytes() {
+	savedBytesMutex.Lock()
+	defer savedBytesMutex.Unlock()
+
+	var dirs []string
+	for dir := range savedBytes {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	var total int64
+	for _, dir := range dirs {
+		fmt.Printf("Saved %v bytes in '%v'\n", savedBytes[dir], dir)
+		total += savedBytes[dir]
+	}
+	fmt.Printf("Saved %v bytes in total\n", total)
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+)
+
+const (
+	defaultSortLayout = "{YYYY}/{MM}-{MonthName}"
+)
+
+var (
+	sortDest   string // when set, files are sorted into this directory instead of renamed in place
+	sortLayout = defaultSortLayout
+	sortCopy   bool // copy files into sortDest instead of moving them
+	eventName  string
+)
+
+// targetDir returns the directory where path, with the metadata info, should be.
+func targetDir(path string, info imageInfo, seq int) string {
+	if sortDest == "" {
+		return filepath.Dir(path)
+	}
+
+	// trim spaces left by empty placeholders, e.g. '{DD} {event}' without event
+	segments := strings.Split(expandTemplate(sortLayout, info, seq), "/")
+	for i := range segments {
+		segments[i] = strings.TrimSpace(segments[i])
+	}
+	return filepath.Join(sortDest, filepath.Join(segments...))
+}
+
+// commitFile moves, or copies when sorting with sortCopy, path to newFile.
+func commitFile(path string, newFile string) error {
+	if dir := filepath.Dir(newFile); dir != filepath.Dir(path) {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			return err
+		}
+	}
+
+	if sortDest != "" && sortCopy {
+		return copyFile(path, newFile)
+	}
+
+	return moveFile(path, newFile)
+}
+
+// moveFile renames path to newFile, copying it when newFile is on another
+// device, e.g. in sortDest.
+func moveFile(path string, newFile string) error {
+	err := os.Rename(path, newFile)
+	if linkErr, ok := err.(*os.LinkError); !ok || linkErr.Err != syscall.EXDEV {
+		return err
+	}
+
+	if err := copyFile(path, newFile); err != nil {
+		return err
+	}
+	return os.Remove(path)
+}
+
+// copyFile copies path to newFile, keeping its mode and ModTime.
+func copyFile(path string, newFile string) error {
+	in, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	fstat, err := in.Stat()
+	if err != nil {
+		return err
+	}
+
+	out, err := os.OpenFile(newFile, os.O_WRONLY|os.O_CREATE|os.O_EXCL, fstat.Mode())
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		os.Remove(newFile)
+		return err
+	}
+	if err := out.Close(); err != nil {
+		os.Remove(newFile)
+		return err
+	}
+
+	return os.Chtimes(newFile, fstat.ModTime(), fstat.ModTime())
+}
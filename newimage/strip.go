@@ -0,0 +1,433 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/mateusbraga/tools/executil"
+)
+
+const (
+	tagOrientation = 0x0112
+)
+
+var iccHeader = []byte("ICC_PROFILE\x00")
+
+// strippableTag is a tag that can be kept when stripping metadata.
+type strippableTag struct {
+	Tag    uint16
+	InExif bool // in the exif sub IFD, otherwise in IFD0
+}
+
+// strippableTags are the tags that may be kept, by name.
+var strippableTags = map[string]strippableTag{
+	"Orientation":         {Tag: tagOrientation},
+	"DateTime":            {Tag: 0x0132},
+	"Make":                {Tag: 0x010f},
+	"Model":               {Tag: 0x0110},
+	"Artist":              {Tag: 0x013b},
+	"Copyright":           {Tag: 0x8298},
+	"DateTimeOriginal":    {Tag: tagDateTimeOriginal, InExif: true},
+	"DateTimeDigitized":   {Tag: 0x9004, InExif: true},
+	"OffsetTime":          {Tag: 0x9010, InExif: true},
+	"OffsetTimeOriginal":  {Tag: tagOffsetTimeOriginal, InExif: true},
+	"OffsetTimeDigitized": {Tag: 0x9012, InExif: true},
+	"SubSecTime":          {Tag: 0x9290, InExif: true},
+	"SubSecTimeOriginal":  {Tag: 0x9291, InExif: true},
+	"SubSecTimeDigitized": {Tag: 0x9292, InExif: true},
+}
+
+// jpegtranOrientations are the lossless jpegtran transforms that apply each
+// exif orientation to the pixels.
+var jpegtranOrientations = map[int][]string{
+	2: {"-flip", "horizontal"},
+	3: {"-rotate", "180"},
+	4: {"-flip", "vertical"},
+	5: {"-transpose"},
+	6: {"-rotate", "90"},
+	7: {"-transverse"},
+	8: {"-rotate", "270"},
+}
+
+// tiffTypeSizes are the sizes of the values of each tiff type.
+var tiffTypeSizes = map[uint16]uint32{
+	1: 1, 2: 1, 3: 2, 4: 4, 5: 8, 6: 1, 7: 1, 8: 2, 9: 4, 10: 8, 11: 4, 12: 8,
+}
+
+// jpegSegment is a marker segment of a jpg.
+type jpegSegment struct {
+	Marker  byte
+	Start   int // of the marker
+	End     int
+	Payload []byte
+}
+
+// runStrip is the strip subcommand: it removes the metadata of jpgs, keeping
+// the image data as is.
+func runStrip(args []string) {
+	flags := flag.NewFlagSet("strip", flag.ExitOnError)
+	keepFlag := flags.String("keep", "", "Comma separated exif tags to keep, e.g. 'Orientation,DateTimeOriginal'. All are removed by default")
+	inPlaceFlag := flags.Bool("in-place", false, "Replace the jpgs instead of writing stripped copies")
+	outputDirFlag := flags.String("output-dir", "", "Write the stripped copies into this directory")
+	flags.Parse(args)
+
+	keep := make(map[string]bool)
+	for _, name := range strings.Split(*keepFlag, ",") {
+		if name = strings.TrimSpace(name); name == "" {
+			continue
+		}
+		if _, ok := strippableTags[name]; !ok {
+			var names []string
+			for name := range strippableTags {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			log.Fatalf("Tag '%v' can not be kept, use %v\n", name, strings.Join(names, ","))
+		}
+		keep[name] = true
+	}
+	if !keep["Orientation"] {
+		if err := executil.HasExecutables("jpegtran"); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *outputDirFlag != "" {
+		if err := os.MkdirAll(*outputDirFlag, 0755); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	jpgs := map[string]bool{".jpg": true, ".jpeg": true}
+	for _, arg := range flags.Args() {
+		root, isRecursive := arg, false
+		if arg == "./..." {
+			root, isRecursive = ".", true
+		}
+		if fstat, err := os.Stat(root); err == nil && !fstat.IsDir() {
+			if err := stripFile(root, keep, *inPlaceFlag, *outputDirFlag); err != nil {
+				fmt.Println(err)
+			}
+			continue
+		}
+
+		done := make(chan struct{})
+		paths, errc := walkFiles(done, root, isRecursive, jpgs, "")
+		for path := range paths {
+			if strings.HasSuffix(path, " - stripped"+filepath.Ext(path)) {
+				continue
+			}
+			if err := stripFile(path, keep, *inPlaceFlag, *outputDirFlag); err != nil {
+				fmt.Println(err)
+			}
+		}
+		close(done)
+		if err := <-errc; err != nil {
+			log.Fatalln(err)
+		}
+	}
+}
+
+// stripFile writes path without metadata to its stripped copy, to outputDir
+// or over itself if inPlace.
+func stripFile(path string, keep map[string]bool, inPlace bool, outputDir string) error {
+	base := filepath.Base(path)
+	newFile := filepath.Join(filepath.Dir(path), base[:len(base)-len(filepath.Ext(base))]+" - stripped"+filepath.Ext(base))
+	switch {
+	case inPlace:
+		newFile = path
+	case outputDir != "":
+		newFile = filepath.Join(outputDir, base)
+	}
+	if !inPlace {
+		if _, err := os.Stat(newFile); err == nil {
+			return fmt.Errorf("'%v' already exists, not overwriting it", newFile)
+		}
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	stripped, err := stripJpeg(path, data, keep)
+	if err != nil {
+		return fmt.Errorf("'%v': %v", path, err)
+	}
+
+	fstat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tempFile := newFile + ".stripped" + filepath.Ext(newFile)
+	if err := ioutil.WriteFile(tempFile, stripped, fstat.Mode()); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	if err := os.Rename(tempFile, newFile); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+
+	fmt.Printf("%v -> %v (-%v bytes)\n", base, filepath.Base(newFile), len(data)-len(stripped))
+	return nil
+}
+
+// stripJpeg returns data, the jpg in path, without exif, XMP, IPTC and other
+// metadata segments, except the tags in keep, and without anything appended
+// after the image. The ICC profile is kept. When the orientation is not kept,
+// it is applied to the pixels with jpegtran.
+func stripJpeg(path string, data []byte, keep map[string]bool) ([]byte, error) {
+	segments, err := jpegSegments(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var tiff []byte
+	var icc [][]byte
+	for _, segment := range segments {
+		switch {
+		case segment.Marker == 0xe1 && bytes.HasPrefix(segment.Payload, exifHeader) && tiff == nil:
+			tiff = segment.Payload[len(exifHeader):]
+		case segment.Marker == 0xe2 && bytes.HasPrefix(segment.Payload, iccHeader):
+			icc = append(icc, data[segment.Start:segment.End])
+		}
+	}
+
+	var exifTiff []byte
+	pixels := data
+	if tiff != nil {
+		exifTiff, err = filterTiff(tiff, keep)
+		if err != nil {
+			return nil, err
+		}
+
+		if orientation := tiffOrientation(tiff); !keep["Orientation"] && jpegtranOrientations[orientation] != nil {
+			pixels, err = orientJpeg(path, orientation)
+			if err != nil {
+				return nil, err
+			}
+			if segments, err = jpegSegments(pixels); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	// SOI, then JFIF, exif and ICC, then the rest without metadata
+	out := append([]byte{}, pixels[:2]...)
+	if len(segments) > 0 && segments[0].Marker == 0xe0 {
+		out = append(out, pixels[segments[0].Start:segments[0].End]...)
+		segments = segments[1:]
+	}
+	if exifTiff != nil {
+		payload := append(append([]byte{}, exifHeader...), exifTiff...)
+		out, err = replaceSegment(out, len(out), len(out), 0xe1, payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	for _, segment := range icc {
+		out = append(out, segment...)
+	}
+
+	for _, segment := range segments {
+		if isStructuralSegment(segment.Marker) {
+			out = append(out, pixels[segment.Start:segment.End]...)
+		}
+	}
+	if end := segments[len(segments)-1].End; end < len(pixels) {
+		// e.g. the video of a motion photo, or a second image with its own metadata
+		log.Printf("'%v': removed %v bytes after the end of the image\n", path, len(pixels)-end)
+	}
+	return out, nil
+}
+
+// isStructuralSegment returns true for segments needed to decode the image:
+// everything but APP1 to APP13, APP15 and comments. APP0 is JFIF and APP14
+// the Adobe color transform.
+func isStructuralSegment(marker byte) bool {
+	if marker == 0xfe {
+		return false
+	}
+	return marker < 0xe1 || marker == 0xee
+}
+
+// jpegSegments returns the segments of data up to the end of its first image,
+// so anything appended after it is left out. A start of scan segment includes
+// the image data that follows it.
+func jpegSegments(data []byte) ([]jpegSegment, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a jpg")
+	}
+
+	var segments []jpegSegment
+	offset := 2
+	for offset+2 <= len(data) {
+		if data[offset] != 0xff {
+			return nil, fmt.Errorf("invalid jpg marker at %v", offset)
+		}
+		marker := data[offset+1]
+		if marker == 0xff {
+			// fill byte
+			offset++
+			continue
+		}
+		if marker == 0xd9 {
+			// end of image
+			return append(segments, jpegSegment{Marker: marker, Start: offset, End: offset + 2}), nil
+		}
+		if offset+4 > len(data) {
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, fmt.Errorf("invalid jpg segment at %v", offset)
+		}
+		if marker == 0xda {
+			// start of scan, its image data ends at the next marker other
+			// than a stuffed 0xff or a restart
+			for end < len(data) && !(data[end] == 0xff && end+1 < len(data) && data[end+1] != 0 && (data[end+1] < 0xd0 || data[end+1] > 0xd7)) {
+				end++
+			}
+		}
+
+		segments = append(segments, jpegSegment{Marker: marker, Start: offset, End: end, Payload: data[offset+4 : end]})
+		offset = end
+	}
+	return nil, errors.New("jpg has no end of image")
+}
+
+// filterTiff returns a new tiff with only the tags of tiff in keep, or nil if
+// none of them is in tiff. The thumbnail is removed.
+func filterTiff(tiff []byte, keep map[string]bool) ([]byte, error) {
+	if len(keep) == 0 {
+		return nil, nil
+	}
+	order, err := tiffByteOrder(tiff)
+	if err != nil {
+		return nil, err
+	}
+
+	ifd0, _, err := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	if err != nil {
+		return nil, err
+	}
+	var exifIFD []ifdEntry
+	for _, entry := range ifd0 {
+		if entry.Tag == tagExifIFDPointer {
+			if exifIFD, _, err = readIFD(tiff, order, order.Uint32(entry.Value[:])); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	kept := func(entries []ifdEntry, inExif bool) []ifdEntry {
+		var filtered []ifdEntry
+		for _, entry := range entries {
+			for name := range keep {
+				if t := strippableTags[name]; t.Tag == entry.Tag && t.InExif == inExif {
+					filtered = append(filtered, entry)
+				}
+			}
+		}
+		return filtered
+	}
+	keptIFD0, keptExif := kept(ifd0, false), kept(exifIFD, true)
+	if len(keptIFD0) == 0 && len(keptExif) == 0 {
+		return nil, nil
+	}
+
+	// in the byte order of tiff, so the values are copied as they are
+	stripped := append([]byte{}, tiff[:4]...)
+	stripped = append(stripped, 0, 0, 0, 0)
+	if stripped, keptExif, err = copyTiffValues(tiff, stripped, order, keptExif); err != nil {
+		return nil, err
+	}
+	if stripped, keptIFD0, err = copyTiffValues(tiff, stripped, order, keptIFD0); err != nil {
+		return nil, err
+	}
+
+	if len(keptExif) > 0 {
+		var exifOffset uint32
+		stripped, exifOffset = appendIFD(stripped, order, keptExif, 0)
+		pointer := ifdEntry{Tag: tagExifIFDPointer, Type: tiffTypeLong, Count: 1}
+		order.PutUint32(pointer.Value[:], exifOffset)
+		keptIFD0 = append(keptIFD0, pointer)
+	}
+	var ifd0Offset uint32
+	stripped, ifd0Offset = appendIFD(stripped, order, keptIFD0, 0)
+	order.PutUint32(stripped[4:8], ifd0Offset)
+	return stripped, nil
+}
+
+// copyTiffValues appends the values of entries stored outside of them, in
+// tiff, to dst, and returns the entries pointing to the copies.
+func copyTiffValues(tiff []byte, dst []byte, order binary.ByteOrder, entries []ifdEntry) ([]byte, []ifdEntry, error) {
+	copied := make([]ifdEntry, len(entries))
+	for i, entry := range entries {
+		copied[i] = entry
+		size := uint64(tiffTypeSizes[entry.Type]) * uint64(entry.Count)
+		if size <= 4 {
+			continue
+		}
+
+		offset := uint64(order.Uint32(entry.Value[:]))
+		if offset+size > uint64(len(tiff)) {
+			return nil, nil, fmt.Errorf("invalid value offset of tag 0x%04x", entry.Tag)
+		}
+		dst = alignWord(dst)
+		order.PutUint32(copied[i].Value[:], uint32(len(dst)))
+		dst = append(dst, tiff[offset:offset+size]...)
+	}
+	return dst, copied, nil
+}
+
+// tiffOrientation returns the exif orientation of tiff, 1 if unknown.
+func tiffOrientation(tiff []byte) int {
+	order, err := tiffByteOrder(tiff)
+	if err != nil {
+		return 1
+	}
+	ifd0, _, err := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	if err != nil {
+		return 1
+	}
+	for _, entry := range ifd0 {
+		if entry.Tag == tagOrientation && entry.Type == 3 {
+			return int(order.Uint16(entry.Value[:2]))
+		}
+	}
+	return 1
+}
+
+// orientJpeg returns the jpg in path transformed, losslessly, as orientation
+// says it should be displayed. The metadata is not copied.
+func orientJpeg(path string, orientation int) ([]byte, error) {
+	tempFile := path + ".oriented" + filepath.Ext(path)
+	defer os.Remove(tempFile)
+
+	// jpegtran -copy none -perfect -rotate 90 -outfile output.jpg input.jpg
+	transform := jpegtranOrientations[orientation]
+	args := append([]string{"-copy", "none", "-perfect"}, transform...)
+	jpegtran := exec.Command("jpegtran", append(args, "-outfile", tempFile, path)...)
+	if _, err := executil.RunWithVerboseError(jpegtran); err != nil {
+		// the image size is not a multiple of the block size, drop the partial blocks at the edges
+		args = append([]string{"-copy", "none", "-trim"}, transform...)
+		jpegtran = exec.Command("jpegtran", append(args, "-outfile", tempFile, path)...)
+		if _, err := executil.RunWithVerboseError(jpegtran); err != nil {
+			return nil, err
+		}
+		log.Printf("'%v': its size is not a multiple of the jpg block size, the partial blocks at its edges were cropped to rotate it losslessly\n", path)
+	}
+
+	return ioutil.ReadFile(tempFile)
+}
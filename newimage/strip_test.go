@@ -0,0 +1,200 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"testing"
+)
+
+// testShort returns the value of a tiff SHORT in order.
+func testShort(order binary.ByteOrder, value uint16) []byte {
+	data := make([]byte, 2)
+	order.PutUint16(data, value)
+	return data
+}
+
+func TestJpegSegments(t *testing.T) {
+	soi := []byte{0xff, 0xd8}
+	jfif := testSegment(0xe0, "JFIF\x00\x01\x01")
+	scan := append(testSegment(0xda, "scan"), "pix\xff\x00els\xff\xd0more"...)
+	eoi := []byte{0xff, 0xd9}
+
+	tests := []struct {
+		name    string
+		data    []byte
+		markers []byte
+		end     int
+		err     bool
+	}{
+		{"segments", bytes.Join([][]byte{soi, jfif, scan, eoi}, nil), []byte{0xe0, 0xda, 0xd9}, 2 + len(jfif) + len(scan) + 2, false},
+		{"fill bytes", bytes.Join([][]byte{soi, {0xff}, jfif, {0xff, 0xff}, scan, eoi}, nil), []byte{0xe0, 0xda, 0xd9}, 5 + len(jfif) + len(scan) + 2, false},
+		{"trailer", bytes.Join([][]byte{soi, scan, eoi, soi, testSegment(0xe1, "Exif"), eoi}, nil), []byte{0xda, 0xd9}, 2 + len(scan) + 2, false},
+		{"no end of image", bytes.Join([][]byte{soi, jfif, scan}, nil), nil, 0, true},
+		{"not a jpg", []byte("GIF89a"), nil, 0, true},
+		{"invalid segment", bytes.Join([][]byte{soi, jfif[:6]}, nil), nil, 0, true},
+		{"invalid marker", bytes.Join([][]byte{soi, []byte("xx"), eoi}, nil), nil, 0, true},
+	}
+
+	for _, test := range tests {
+		segments, err := jpegSegments(test.data)
+		if (err != nil) != test.err {
+			t.Errorf("%v: jpegSegments() error = %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+
+		var markers []byte
+		for _, segment := range segments {
+			markers = append(markers, segment.Marker)
+		}
+		if !bytes.Equal(markers, test.markers) {
+			t.Errorf("%v: jpegSegments() markers = %x, want %x", test.name, markers, test.markers)
+		}
+		if end := segments[len(segments)-1].End; end != test.end {
+			t.Errorf("%v: jpegSegments() ends at %v, want %v", test.name, end, test.end)
+		}
+	}
+}
+
+func TestFilterTiff(t *testing.T) {
+	tiff := func(order binary.ByteOrder) []byte {
+		return testTiff(order,
+			[]testTag{
+				{tagOrientation, 3, testShort(order, 6)},
+				{0x010f, tiffTypeASCII, []byte("Canon\x00")},
+				{0x0110, tiffTypeASCII, []byte("EOS 5D Mark IV\x00")},
+			},
+			[]testTag{
+				{tagDateTimeOriginal, tiffTypeASCII, []byte("2019:05:04 15:14:15\x00")},
+				{0x927c, 7, []byte("maker notes")},
+			})
+	}
+	invalidOffset := tiff(binary.BigEndian)
+	binary.BigEndian.PutUint32(invalidOffset[18:], 0xfff0)
+
+	tests := []struct {
+		name string
+		tiff []byte
+		keep []string
+		tags map[uint16]string
+		err  bool
+	}{
+		{"keep nothing", tiff(binary.BigEndian), nil, nil, false},
+		{"keep absent tag", tiff(binary.BigEndian), []string{"Artist"}, nil, false},
+		{"keep IFD0 tags", tiff(binary.BigEndian), []string{"Orientation", "Model"}, map[uint16]string{tagOrientation: "6", 0x0110: "EOS 5D Mark IV"}, false},
+		{"keep exif tags", tiff(binary.BigEndian), []string{"DateTimeOriginal"}, map[uint16]string{tagDateTimeOriginal: "2019:05:04 15:14:15"}, false},
+		{"little endian", tiff(binary.LittleEndian), []string{"Make", "DateTimeOriginal"}, map[uint16]string{0x010f: "Canon", tagDateTimeOriginal: "2019:05:04 15:14:15"}, false},
+		{"invalid value offset", invalidOffset, []string{"DateTimeOriginal"}, nil, true},
+		{"invalid byte order", []byte("XX\x00\x2a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00"), []string{"Make"}, nil, true},
+	}
+
+	for _, test := range tests {
+		keep := make(map[string]bool)
+		for _, name := range test.keep {
+			keep[name] = true
+		}
+
+		filtered, err := filterTiff(test.tiff, keep)
+		if (err != nil) != test.err {
+			t.Errorf("%v: filterTiff() error = %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if test.tags == nil {
+			if filtered != nil {
+				t.Errorf("%v: filterTiff() = %q, want nil", test.name, filtered)
+			}
+			continue
+		}
+		if tags := testTiffTags(t, filtered); !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("%v: filterTiff() tags = %q, want %q", test.name, tags, test.tags)
+		}
+		if bytes.Contains(filtered, []byte("maker notes")) {
+			t.Errorf("%v: filterTiff() kept the maker notes", test.name)
+		}
+	}
+}
+
+func TestStripJpeg(t *testing.T) {
+	tiff := testTiff(binary.BigEndian,
+		[]testTag{
+			{tagOrientation, 3, testShort(binary.BigEndian, 1)},
+			{0x010f, tiffTypeASCII, []byte("secret\x00")},
+		},
+		[]testTag{{tagDateTimeOriginal, tiffTypeASCII, []byte("2019:05:04 15:14:15\x00")}})
+	data := bytes.Join([][]byte{
+		{0xff, 0xd8},
+		testSegment(0xe0, "JFIF\x00\x01\x01"),
+		testSegment(0xe1, "http://ns.adobe.com/xap/1.0/\x00secret"),
+		testSegment(0xe1, string(exifHeader)+string(tiff)),
+		testSegment(0xe2, string(iccHeader)+"\x01\x01profile"),
+		testSegment(0xed, "Photoshop 3.0\x00secret"),
+		testSegment(0xee, "Adobe"),
+		testSegment(0xfe, "secret"),
+		testSegment(0xdb, "tables"),
+		append(testSegment(0xda, "scan"), "pixels"...),
+		{0xff, 0xd9},
+		{0xff, 0xd8},
+		testSegment(0xe1, "secret"),
+		{0xff, 0xd9},
+	}, nil)
+
+	tests := []struct {
+		name    string
+		keep    []string
+		markers []byte
+		tags    map[uint16]string
+	}{
+		{"keep nothing", nil, []byte{0xe0, 0xe2, 0xee, 0xdb, 0xda, 0xd9}, nil},
+		{"keep date", []string{"DateTimeOriginal", "Orientation"}, []byte{0xe0, 0xe1, 0xe2, 0xee, 0xdb, 0xda, 0xd9}, map[uint16]string{tagDateTimeOriginal: "2019:05:04 15:14:15", tagOrientation: "1"}},
+	}
+
+	for _, test := range tests {
+		keep := make(map[string]bool)
+		for _, name := range test.keep {
+			keep[name] = true
+		}
+
+		stripped, err := stripJpeg("a.jpg", data, keep)
+		if err != nil {
+			t.Errorf("%v: stripJpeg() error = %v", test.name, err)
+			continue
+		}
+		if bytes.Contains(stripped, []byte("secret")) {
+			t.Errorf("%v: stripJpeg() kept metadata", test.name)
+		}
+
+		segments, err := jpegSegments(stripped)
+		if err != nil {
+			t.Errorf("%v: stripJpeg() is not a jpg: %v", test.name, err)
+			continue
+		}
+		var markers []byte
+		for _, segment := range segments {
+			markers = append(markers, segment.Marker)
+		}
+		if !bytes.Equal(markers, test.markers) {
+			t.Errorf("%v: stripJpeg() markers = %x, want %x", test.name, markers, test.markers)
+		}
+		if end := segments[len(segments)-1].End; end != len(stripped) {
+			t.Errorf("%v: stripJpeg() left %v bytes after the end of the image", test.name, len(stripped)-end)
+		}
+
+		tiffs, _ := testJpegExif(t, stripped)
+		if test.tags == nil {
+			if len(tiffs) != 0 {
+				t.Errorf("%v: stripJpeg() kept the exif", test.name)
+			}
+			continue
+		}
+		if len(tiffs) != 1 {
+			t.Errorf("%v: stripJpeg() has %v exif segments, want 1", test.name, len(tiffs))
+			continue
+		}
+		if tags := testTiffTags(t, tiffs[0]); !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("%v: stripJpeg() tags = %q, want %q", test.name, tags, test.tags)
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	defaultNameTemplate = "{YYYY}-{MM}-{DD} {hh}.{mm}.{ss}"
+)
+
+var templatePlaceholderRegexp = regexp.MustCompile(`\{(\w+)\}`)
+
+// templatePlaceholders expands each {placeholder} of a name template.
+var templatePlaceholders = map[string]func(info imageInfo, seq int) string{
+	"YYYY":      func(info imageInfo, seq int) string { return info.Time.Format("2006") },
+	"YY":        func(info imageInfo, seq int) string { return info.Time.Format("06") },
+	"MM":        func(info imageInfo, seq int) string { return info.Time.Format("01") },
+	"MonthName": func(info imageInfo, seq int) string { return info.Time.Format("January") },
+	"DD":        func(info imageInfo, seq int) string { return info.Time.Format("02") },
+	"hh":        func(info imageInfo, seq int) string { return info.Time.Format("15") },
+	"mm":        func(info imageInfo, seq int) string { return info.Time.Format("04") },
+	"ss":        func(info imageInfo, seq int) string { return info.Time.Format("05") },
+	"make":      func(info imageInfo, seq int) string { return info.Make },
+	"model":     func(info imageInfo, seq int) string { return info.Model },
+	"lens":      func(info imageInfo, seq int) string { return info.Lens },
+	"name": func(info imageInfo, seq int) string {
+		base := filepath.Base(info.Path)
+		return base[:len(base)-len(filepath.Ext(base))]
+	},
+	"seq":     func(info imageInfo, seq int) string { return fmt.Sprintf("%04d", seq) },
+	"ext":     func(info imageInfo, seq int) string { return strings.TrimPrefix(filepath.Ext(info.Path), ".") },
+	"event":   func(info imageInfo, seq int) string { return eventName },
+	"city":    func(info imageInfo, seq int) string { return info.City },
+	"country": func(info imageInfo, seq int) string { return info.Country },
+}
+
+// checkTemplate returns an error if template has unknown placeholders.
+func checkTemplate(template string) error {
+	for _, match := range templatePlaceholderRegexp.FindAllStringSubmatch(template, -1) {
+		if _, ok := templatePlaceholders[match[1]]; !ok {
+			return fmt.Errorf("unknown placeholder '%v' in template '%v'", match[0], template)
+		}
+	}
+	return nil
+}
+
+// usesLocationNames returns true if template has placeholders expanded by the
+// geocoder, which are empty without it.
+func usesLocationNames(template string) bool {
+	for _, match := range templatePlaceholderRegexp.FindAllStringSubmatch(template, -1) {
+		if match[1] == "city" || match[1] == "country" {
+			return true
+		}
+	}
+	return false
+}
+
+// expandTemplate replaces the placeholders of template with the metadata of
+// info. seq is the position of the file in this run.
+func expandTemplate(template string, info imageInfo, seq int) string {
+	return templatePlaceholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		expand, ok := templatePlaceholders[placeholder[1:len(placeholder)-1]]
+		if !ok {
+			return placeholder
+		}
+		return sanitizeName(expand(info, seq))
+	})
+}
+
+// sanitizeName makes value safe to be used inside a file name.
+func sanitizeName(value string) string {
+	r := strings.NewReplacer("/", "-", "\\", "-", ":", "-", "\x00", "")
+	return strings.TrimSpace(r.Replace(value))
+}
+
+// hasTemplateName returns true if path is in dir with a name that template
+// expands to for info, with any {name} and {seq} and a collision suffix. Such
+// files were already renamed with template: as {name} and {seq} change with
+// each run, renaming them again would not keep their names.
+func hasTemplateName(path string, dir string, template string, info imageInfo) bool {
+	const nameMarker, seqMarker = "\x01name\x01", "\x01seq\x01"
+	if filepath.Dir(path) != dir {
+		return false
+	}
+
+	name := templatePlaceholderRegexp.ReplaceAllStringFunc(template, func(placeholder string) string {
+		switch placeholder {
+		case "{name}":
+			return nameMarker
+		case "{seq}":
+			return seqMarker
+		}
+		return expandTemplate(placeholder, info, 0)
+	})
+	if !strings.Contains(template, "{ext}") {
+		name += filepath.Ext(path)
+	}
+	ext := filepath.Ext(name)
+	base := name[:len(name)-len(ext)]
+	if !strings.Contains(base, nameMarker) && !strings.Contains(base, seqMarker) {
+		return false
+	}
+
+	pattern := regexp.QuoteMeta(base)
+	pattern = strings.Replace(pattern, nameMarker, ".+", -1)
+	pattern = strings.Replace(pattern, seqMarker, `\d{4,}`, -1)
+	matched, err := regexp.MatchString("^"+pattern+`(\.\d+|_\d+)?`+regexp.QuoteMeta(ext)+"$", filepath.Base(path))
+	return err == nil && matched
+}
@@ -0,0 +1,34 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestHasTemplateName(t *testing.T) {
+	info := imageInfo{Path: "/photos/IMG_1.jpg", Time: time.Date(2019, time.January, 2, 3, 4, 5, 0, time.UTC), Make: "Canon"}
+
+	tests := []struct {
+		path     string
+		template string
+		named    bool
+	}{
+		{"/photos/20190102_IMG_1.jpg", "{YYYY}{MM}{DD}_{name}", true},
+		{"/photos/20190102_IMG_1_1.jpg", "{YYYY}{MM}{DD}_{name}", true},
+		{"/photos/IMG_1.jpg", "{YYYY}{MM}{DD}_{name}", false},
+		{"/photos/20190103_IMG_1.jpg", "{YYYY}{MM}{DD}_{name}", false},
+		{"/other/20190102_IMG_1.jpg", "{YYYY}{MM}{DD}_{name}", false},
+		{"/photos/2019-01-02 0012.jpg", "{YYYY}-{MM}-{DD} {seq}", true},
+		{"/photos/2019-01-02 0012.123.jpg", "{YYYY}-{MM}-{DD} {seq}", true},
+		{"/photos/2019-01-02 12.jpg", "{YYYY}-{MM}-{DD} {seq}", false},
+		{"/photos/Canon IMG_1.jpg", "{make} {name}.{ext}", true},
+		{"/photos/Canon+IMG_1.jpg", "{make} {name}.{ext}", false},
+		{"/photos/2019-01-02 03.04.05.jpg", defaultNameTemplate, false},
+	}
+
+	for _, test := range tests {
+		if named := hasTemplateName(test.path, "/photos", test.template, info); named != test.named {
+			t.Errorf("hasTemplateName(%q, %q) = %v, want %v", test.path, test.template, named, test.named)
+		}
+	}
+}
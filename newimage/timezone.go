@@ -0,0 +1,161 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/rwcarlsen/goexif/exif"
+	"github.com/rwcarlsen/goexif/tiff"
+)
+
+const (
+	offsetTime          exif.FieldName = "OffsetTime"
+	offsetTimeOriginal  exif.FieldName = "OffsetTimeOriginal"
+	offsetTimeDigitized exif.FieldName = "OffsetTimeDigitized"
+)
+
+// offsetTimeFields are the exif 2.31 timezone tags, unknown to goexif.
+var offsetTimeFields = map[uint16]exif.FieldName{
+	0x9010: offsetTime,
+	0x9011: offsetTimeOriginal,
+	0x9012: offsetTimeDigitized,
+}
+
+var (
+	cameraOffsets = make(offsetsFlag) // clock offset per camera model
+	dirOffsets    = make(offsetsFlag) // clock offset per directory
+	timezone      *time.Location      // timezone of the names, nil keeps the wall clock of each photo
+)
+
+// offsetsFlag is a repeatable flag of NAME=DURATION values, e.g. 'Canon EOS 80D=-1h30m'.
+type offsetsFlag map[string]time.Duration
+
+func (o offsetsFlag) String() string {
+	var values []string
+	for name, offset := range o {
+		values = append(values, fmt.Sprintf("%v=%v", name, offset))
+	}
+	sort.Strings(values)
+	return strings.Join(values, ",")
+}
+
+func (o offsetsFlag) Set(value string) error {
+	i := strings.LastIndex(value, "=")
+	if i <= 0 {
+		return fmt.Errorf("'%v' is not NAME=DURATION", value)
+	}
+	offset, err := time.ParseDuration(value[i+1:])
+	if err != nil {
+		return err
+	}
+	o[value[:i]] = offset
+	return nil
+}
+
+// offsetTimeParser loads the OffsetTime tags of the exif sub ifd.
+type offsetTimeParser struct{}
+
+func (offsetTimeParser) Parse(x *exif.Exif) error {
+	tag, err := x.Get(exif.ExifIFDPointer)
+	if err != nil {
+		// no exif sub ifd
+		return nil
+	}
+	offset, err := tag.Int64(0)
+	if err != nil {
+		return nil
+	}
+
+	r := bytes.NewReader(x.Raw)
+	if _, err := r.Seek(offset, 0); err != nil {
+		return fmt.Errorf("exif sub ifd seek: %v", err)
+	}
+	dir, _, err := tiff.DecodeDir(r, x.Tiff.Order)
+	if err != nil {
+		return fmt.Errorf("exif sub ifd decode: %v", err)
+	}
+	x.LoadTags(dir, offsetTimeFields, false)
+	return nil
+}
+
+// exifTimezone returns the timezone in field of x, one of the OffsetTime
+// tags, e.g. '+01:00'.
+func exifTimezone(x *exif.Exif, field exif.FieldName) (*time.Location, bool) {
+	value := exifString(x, field)
+	t, err := time.Parse(exifOffsetLayout, value)
+	if err != nil {
+		return nil, false
+	}
+	_, offset := t.Zone()
+	return time.FixedZone(value, offset), true
+}
+
+// inTimezone returns the instant with the wall clock of t in loc.
+func inTimezone(t time.Time, loc *time.Location) time.Time {
+	return time.Date(t.Year(), t.Month(), t.Day(), t.Hour(), t.Minute(), t.Second(), t.Nanosecond(), loc)
+}
+
+// correctTime applies the clock offsets of the camera and directory of info
+// and converts the result to timezone. Without timezone, the wall clock of
+// each file is kept, as photos without OffsetTimeOriginal only have that.
+func correctTime(info imageInfo) time.Time {
+	t := info.Time.Add(cameraOffsets[info.Model]).Add(dirOffset(info.Path))
+	if timezone != nil {
+		return t.In(timezone)
+	}
+	return inTimezone(t, time.Local)
+}
+
+// dirOffset returns the offset of the deepest directory of dirOffsets
+// containing path.
+func dirOffset(path string) time.Duration {
+	var offset time.Duration
+	var longest int
+	for dir, dirOffset := range dirOffsets {
+		abs, err := filepath.Abs(dir)
+		if err != nil {
+			continue
+		}
+		if (path == abs || strings.HasPrefix(path, abs+string(filepath.Separator))) && len(abs) > longest {
+			offset = dirOffset
+			longest = len(abs)
+		}
+	}
+	return offset
+}
+
+// clockOffset returns the offset of the camera clock from a photo of a clock
+// showing clockTime, e.g. '15:04:05'. The offset is within 12 hours, as only
+// the time of day is known.
+func clockOffset(path string, clockTime string) (string, time.Duration, error) {
+	shown, err := time.Parse("15:04:05", clockTime)
+	if err != nil {
+		shown, err = time.Parse("15:04", clockTime)
+		if err != nil {
+			return "", 0, fmt.Errorf("clock time '%v' is not HH:MM[:SS]", clockTime)
+		}
+	}
+
+	info, err := readUncorrectedImageInfo(path)
+	if err != nil {
+		return "", 0, err
+	}
+	if info.Model == "" {
+		return "", 0, fmt.Errorf("'%v' has no camera model", path)
+	}
+
+	taken := info.Time
+	actual := time.Date(taken.Year(), taken.Month(), taken.Day(), shown.Hour(), shown.Minute(), shown.Second(), 0, taken.Location())
+	offset := actual.Sub(taken.Truncate(time.Second))
+	for offset > 12*time.Hour {
+		offset -= 24 * time.Hour
+	}
+	for offset < -12*time.Hour {
+		offset += 24 * time.Hour
+	}
+	return info.Model, offset, nil
+}
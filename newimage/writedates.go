@@ -0,0 +1,379 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	exifDateLayout   = "2006:01:02 15:04:05"
+	exifOffsetLayout = "-07:00"
+
+	tagExifIFDPointer     = 0x8769
+	tagDateTimeOriginal   = 0x9003
+	tagOffsetTimeOriginal = 0x9011
+
+	tiffTypeASCII = 2
+	tiffTypeLong  = 4
+)
+
+var exifHeader = []byte("Exif\x00\x00")
+
+var (
+	writeDates bool // write the dates of the new names into the files
+)
+
+// writeFileDates writes t as the capture date of path: into the exif of jpgs,
+// the creation atoms of videos and the ModTime of every file.
+func writeFileDates(path string, t time.Time) error {
+	switch ext := strings.ToLower(filepath.Ext(path)); {
+	case ext == ".jpg" || ext == ".jpeg":
+		// the timezone of t is only known when normalizing to one
+		if err := writeJpegDate(path, t, timezone != nil); err != nil {
+			return err
+		}
+	case videoFiletypes[ext]:
+		if err := writeVideoDate(path, t); err != nil {
+			return err
+		}
+	}
+	return os.Chtimes(path, t, t)
+}
+
+// writeJpegDate sets DateTimeOriginal, and OffsetTimeOriginal if withOffset,
+// of the jpg in path to t. The image data is not changed.
+func writeJpegDate(path string, t time.Time, withOffset bool) error {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	newData, err := setJpegDate(data, t, withOffset)
+	if err != nil {
+		return fmt.Errorf("'%v': %v", path, err)
+	}
+	if bytes.Equal(data, newData) {
+		return nil
+	}
+
+	fstat, err := os.Stat(path)
+	if err != nil {
+		return err
+	}
+	tempFile := path + ".dated" + filepath.Ext(path)
+	if err := ioutil.WriteFile(tempFile, newData, fstat.Mode()); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return os.Rename(tempFile, path)
+}
+
+// setJpegDate returns the jpg data with the date t in its exif. The exif APP1
+// segment is created if absent.
+func setJpegDate(data []byte, t time.Time, withOffset bool) ([]byte, error) {
+	if len(data) < 4 || data[0] != 0xff || data[1] != 0xd8 {
+		return nil, errors.New("not a jpg")
+	}
+
+	// the exif goes after SOI, or after the JFIF APP0
+	insertAt := 2
+	offset := 2
+	for offset+4 <= len(data) {
+		if data[offset] != 0xff {
+			return nil, fmt.Errorf("invalid jpg marker at %v", offset)
+		}
+		marker := data[offset+1]
+		if marker == 0xda || marker == 0xd9 {
+			// start of scan or end of image
+			break
+		}
+		length := int(binary.BigEndian.Uint16(data[offset+2 : offset+4]))
+		end := offset + 2 + length
+		if length < 2 || end > len(data) {
+			return nil, fmt.Errorf("invalid jpg segment at %v", offset)
+		}
+
+		payload := data[offset+4 : end]
+		if marker == 0xe1 && bytes.HasPrefix(payload, exifHeader) {
+			tiff, err := setTiffDate(payload[len(exifHeader):], t, withOffset)
+			if err != nil {
+				return nil, err
+			}
+			return replaceSegment(data, offset, end, 0xe1, append(append([]byte{}, exifHeader...), tiff...))
+		}
+		if marker == 0xe0 && offset == 2 {
+			insertAt = end
+		}
+		offset = end
+	}
+
+	tiff, err := setTiffDate(newTiff(), t, withOffset)
+	if err != nil {
+		return nil, err
+	}
+	return replaceSegment(data, insertAt, insertAt, 0xe1, append(append([]byte{}, exifHeader...), tiff...))
+}
+
+// replaceSegment returns data with data[start:end] replaced by a segment of
+// marker with payload.
+func replaceSegment(data []byte, start, end int, marker byte, payload []byte) ([]byte, error) {
+	if len(payload)+2 > 0xffff {
+		return nil, errors.New("exif is too big for a jpg segment")
+	}
+
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+
+	newData := make([]byte, 0, len(data)-(end-start)+len(segment)+len(payload))
+	newData = append(newData, data[:start]...)
+	newData = append(newData, segment...)
+	newData = append(newData, payload...)
+	return append(newData, data[end:]...), nil
+}
+
+// newTiff returns an empty big endian tiff, with an IFD0 without entries.
+func newTiff() []byte {
+	return []byte{'M', 'M', 0, 42, 0, 0, 0, 8, 0, 0, 0, 0, 0, 0}
+}
+
+// ifdEntry is an entry of a tiff IFD, with its value or offset unparsed.
+type ifdEntry struct {
+	Tag   uint16
+	Type  uint16
+	Count uint32
+	Value [4]byte
+}
+
+// setTiffDate sets DateTimeOriginal, and OffsetTimeOriginal if withOffset, in
+// the exif sub IFD of tiff. Values of the same size are overwritten in place.
+// Otherwise the changed IFDs are appended to tiff and the pointers to them
+// updated, so the offsets of everything else, maker notes included, stay valid.
+func setTiffDate(tiff []byte, t time.Time, withOffset bool) ([]byte, error) {
+	order, err := tiffByteOrder(tiff)
+	if err != nil {
+		return nil, err
+	}
+	tiff = append([]byte{}, tiff...)
+
+	ifd0Offset := order.Uint32(tiff[4:8])
+	ifd0, ifd0Next, err := readIFD(tiff, order, ifd0Offset)
+	if err != nil {
+		return nil, err
+	}
+
+	var exifIFD []ifdEntry
+	var exifNext uint32
+	exifPointer := -1
+	for i, entry := range ifd0 {
+		if entry.Tag == tagExifIFDPointer {
+			exifPointer = i
+			exifIFD, exifNext, err = readIFD(tiff, order, order.Uint32(entry.Value[:]))
+			if err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	values := map[uint16]string{
+		tagDateTimeOriginal: t.Format(exifDateLayout),
+	}
+	if withOffset {
+		values[tagOffsetTimeOriginal] = t.Format(exifOffsetLayout)
+	}
+	changed := false
+	for _, tag := range []uint16{tagDateTimeOriginal, tagOffsetTimeOriginal} {
+		if _, ok := values[tag]; !ok {
+			continue
+		}
+		value := append([]byte(values[tag]), 0)
+
+		found := false
+		for i, entry := range exifIFD {
+			if entry.Tag != tag {
+				continue
+			}
+			found = true
+			if entry.Type == tiffTypeASCII && entry.Count == uint32(len(value)) {
+				// same size, overwrite in place
+				offset := order.Uint32(entry.Value[:])
+				if int(offset)+len(value) > len(tiff) {
+					return nil, errors.New("invalid exif value offset")
+				}
+				copy(tiff[offset:], value)
+				continue
+			}
+
+			tiff, exifIFD[i] = appendASCII(tiff, order, tag, value)
+			changed = true
+		}
+		if !found {
+			var entry ifdEntry
+			tiff, entry = appendASCII(tiff, order, tag, value)
+			exifIFD = append(exifIFD, entry)
+			changed = true
+		}
+	}
+	if !changed {
+		return tiff, nil
+	}
+
+	var exifOffset uint32
+	tiff, exifOffset = appendIFD(tiff, order, exifIFD, exifNext)
+	if exifPointer >= 0 {
+		// point the existing entry of IFD0 to the new exif IFD
+		entryOffset := ifd0Offset + 2 + uint32(exifPointer)*12 + 8
+		order.PutUint32(tiff[entryOffset:], exifOffset)
+		return tiff, nil
+	}
+
+	pointer := ifdEntry{Tag: tagExifIFDPointer, Type: tiffTypeLong, Count: 1}
+	order.PutUint32(pointer.Value[:], exifOffset)
+	ifd0 = append(ifd0, pointer)
+	tiff, ifd0Offset = appendIFD(tiff, order, ifd0, ifd0Next)
+	order.PutUint32(tiff[4:8], ifd0Offset)
+	return tiff, nil
+}
+
+// tiffByteOrder returns the byte order in the header of tiff.
+func tiffByteOrder(tiff []byte) (binary.ByteOrder, error) {
+	if len(tiff) < 8 {
+		return nil, errors.New("invalid tiff header")
+	}
+	switch string(tiff[:2]) {
+	case "II":
+		return binary.LittleEndian, nil
+	case "MM":
+		return binary.BigEndian, nil
+	default:
+		return nil, errors.New("invalid tiff byte order")
+	}
+}
+
+func readIFD(tiff []byte, order binary.ByteOrder, offset uint32) ([]ifdEntry, uint32, error) {
+	if int(offset)+2 > len(tiff) {
+		return nil, 0, errors.New("invalid tiff IFD offset")
+	}
+	count := int(order.Uint16(tiff[offset:]))
+	start := int(offset) + 2
+	if start+count*12+4 > len(tiff) {
+		return nil, 0, errors.New("invalid tiff IFD")
+	}
+
+	entries := make([]ifdEntry, count)
+	for i := range entries {
+		raw := tiff[start+i*12:]
+		entries[i].Tag = order.Uint16(raw[0:2])
+		entries[i].Type = order.Uint16(raw[2:4])
+		entries[i].Count = order.Uint32(raw[4:8])
+		copy(entries[i].Value[:], raw[8:12])
+	}
+	next := order.Uint32(tiff[start+count*12:])
+	return entries, next, nil
+}
+
+// appendASCII appends value to tiff and returns the entry of tag pointing to it.
+func appendASCII(tiff []byte, order binary.ByteOrder, tag uint16, value []byte) ([]byte, ifdEntry) {
+	entry := ifdEntry{Tag: tag, Type: tiffTypeASCII, Count: uint32(len(value))}
+	if len(value) <= 4 {
+		copy(entry.Value[:], value)
+		return tiff, entry
+	}
+
+	tiff = alignWord(tiff)
+	order.PutUint32(entry.Value[:], uint32(len(tiff)))
+	return append(tiff, value...), entry
+}
+
+// appendIFD appends an IFD with entries, sorted by tag as tiff requires, to
+// tiff and returns its offset.
+func appendIFD(tiff []byte, order binary.ByteOrder, entries []ifdEntry, next uint32) ([]byte, uint32) {
+	sorted := append([]ifdEntry{}, entries...)
+	for i := 1; i < len(sorted); i++ {
+		for j := i; j > 0 && sorted[j].Tag < sorted[j-1].Tag; j-- {
+			sorted[j], sorted[j-1] = sorted[j-1], sorted[j]
+		}
+	}
+
+	tiff = alignWord(tiff)
+	offset := uint32(len(tiff))
+
+	ifd := make([]byte, 2+len(sorted)*12+4)
+	order.PutUint16(ifd, uint16(len(sorted)))
+	for i, entry := range sorted {
+		raw := ifd[2+i*12:]
+		order.PutUint16(raw[0:2], entry.Tag)
+		order.PutUint16(raw[2:4], entry.Type)
+		order.PutUint32(raw[4:8], entry.Count)
+		copy(raw[8:12], entry.Value[:])
+	}
+	order.PutUint32(ifd[2+len(sorted)*12:], next)
+	return append(tiff, ifd...), offset
+}
+
+func alignWord(tiff []byte) []byte {
+	if len(tiff)%2 != 0 {
+		return append(tiff, 0)
+	}
+	return tiff
+}
+
+// writeVideoDate sets the creation and modification times of the mvhd, tkhd
+// and mdhd atoms of the video in path to t, and the QuickTime creationdate if
+// it has one. The atoms are changed in place.
+func writeVideoDate(path string, t time.Time) error {
+	f, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	offset, moov, err := locateTopLevelAtom(f, "moov")
+	if err != nil {
+		return fmt.Errorf("'%v': %v", path, err)
+	}
+
+	setHeaderTimes(findAtom(moov, "mvhd"), t)
+	for _, trak := range findAtoms(moov, "trak") {
+		setHeaderTimes(findAtom(trak, "tkhd"), t)
+		setHeaderTimes(findAtom(findAtom(trak, "mdia"), "mdhd"), t)
+	}
+	if value := quickTimeItems(moov)["com.apple.quicktime.creationdate"]; value != nil {
+		if date := t.Format(quickTimeDateLayouts[0]); len(date) <= len(value) {
+			copy(value, date+strings.Repeat("\x00", len(value)-len(date)))
+		}
+	}
+
+	if _, err := f.WriteAt(moov, offset); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// setHeaderTimes sets the creation and modification times of an mvhd, tkhd or
+// mdhd payload, in place.
+func setHeaderTimes(data []byte, t time.Time) {
+	if t.Before(mp4Epoch) || len(data) < 4 {
+		return
+	}
+	seconds := uint64(t.Sub(mp4Epoch) / time.Second)
+
+	switch version := data[0]; version {
+	case 0:
+		if len(data) >= 12 && seconds <= 0xffffffff {
+			binary.BigEndian.PutUint32(data[4:8], uint32(seconds))
+			binary.BigEndian.PutUint32(data[8:12], uint32(seconds))
+		}
+	case 1:
+		if len(data) >= 20 {
+			binary.BigEndian.PutUint64(data[4:12], seconds)
+			binary.BigEndian.PutUint64(data[12:20], seconds)
+		}
+	}
+}
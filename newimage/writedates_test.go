@@ -0,0 +1,222 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+)
+
+// testTag is an entry of a test tiff IFD with its value.
+type testTag struct {
+	Tag   uint16
+	Type  uint16
+	Value []byte
+}
+
+// testTiff returns a tiff with the tags of ifd0 and, if any, an exif sub IFD
+// with the tags of exif. Every IFD is followed by its values.
+func testTiff(order binary.ByteOrder, ifd0 []testTag, exif []testTag) []byte {
+	tiff := []byte("MM\x00\x2a\x00\x00\x00\x08")
+	if order == binary.LittleEndian {
+		tiff = []byte("II\x2a\x00\x08\x00\x00\x00")
+	}
+
+	appendTestIFD := func(tags []testTag) uint32 {
+		offset := len(tiff)
+		ifd := make([]byte, 2+len(tags)*12+4)
+		var values []byte
+		order.PutUint16(ifd, uint16(len(tags)))
+		for i, tag := range tags {
+			raw := ifd[2+i*12:]
+			order.PutUint16(raw[0:2], tag.Tag)
+			order.PutUint16(raw[2:4], tag.Type)
+			order.PutUint32(raw[4:8], uint32(len(tag.Value))/tiffTypeSizes[tag.Type])
+			if len(tag.Value) <= 4 {
+				copy(raw[8:12], tag.Value)
+				continue
+			}
+			order.PutUint32(raw[8:12], uint32(offset+len(ifd)+len(values)))
+			values = append(values, tag.Value...)
+		}
+		tiff = append(append(tiff, ifd...), values...)
+		return uint32(offset)
+	}
+
+	if exif != nil {
+		pointer := testTag{Tag: tagExifIFDPointer, Type: tiffTypeLong, Value: make([]byte, 4)}
+		order.PutUint32(pointer.Value, appendTestIFD(exif))
+		ifd0 = append(ifd0, pointer)
+	}
+	ifd0Offset := appendTestIFD(ifd0)
+	order.PutUint32(tiff[4:8], ifd0Offset)
+	return tiff
+}
+
+// testTiffTags returns the ASCII and SHORT values of IFD0 and the exif sub IFD
+// of tiff, by tag.
+func testTiffTags(t *testing.T, tiff []byte) map[uint16]string {
+	order, err := tiffByteOrder(tiff)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ifd0, _, err := readIFD(tiff, order, order.Uint32(tiff[4:8]))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tags := make(map[uint16]string)
+	entries := ifd0
+	for _, entry := range ifd0 {
+		if entry.Tag == tagExifIFDPointer {
+			exifIFD, _, err := readIFD(tiff, order, order.Uint32(entry.Value[:]))
+			if err != nil {
+				t.Fatal(err)
+			}
+			entries = append(entries, exifIFD...)
+		}
+	}
+	for _, entry := range entries {
+		switch entry.Type {
+		case tiffTypeASCII:
+			value := entry.Value[:]
+			if entry.Count > 4 {
+				offset := order.Uint32(entry.Value[:])
+				value = tiff[offset : offset+entry.Count]
+			}
+			tags[entry.Tag] = string(bytes.TrimRight(value[:entry.Count], "\x00"))
+		case 3:
+			tags[entry.Tag] = fmt.Sprint(order.Uint16(entry.Value[:2]))
+		}
+	}
+	return tags
+}
+
+func TestSetTiffDate(t *testing.T) {
+	date := time.Date(2019, time.May, 4, 15, 14, 15, 0, time.FixedZone("", 2*60*60))
+	maker := testTag{0x010f, tiffTypeASCII, []byte("Canon\x00")}
+	sameSize := testTag{tagDateTimeOriginal, tiffTypeASCII, []byte("2000:01:01 00:00:00\x00")}
+	otherSize := testTag{tagDateTimeOriginal, tiffTypeASCII, []byte("2000:01:01\x00")}
+	invalidOffset := testTiff(binary.BigEndian, nil, []testTag{sameSize})
+	binary.BigEndian.PutUint32(invalidOffset[18:], 0xfff0)
+
+	tests := []struct {
+		name       string
+		tiff       []byte
+		withOffset bool
+		tags       map[uint16]string
+		inPlace    bool
+		err        bool
+	}{
+		{"new tiff", newTiff(), false, map[uint16]string{tagDateTimeOriginal: "2019:05:04 15:14:15"}, false, false},
+		{"new tiff with offset", newTiff(), true, map[uint16]string{tagDateTimeOriginal: "2019:05:04 15:14:15", tagOffsetTimeOriginal: "+02:00"}, false, false},
+		{"same size", testTiff(binary.BigEndian, []testTag{maker}, []testTag{sameSize}), false, map[uint16]string{0x010f: "Canon", tagDateTimeOriginal: "2019:05:04 15:14:15"}, true, false},
+		{"same size little endian", testTiff(binary.LittleEndian, []testTag{maker}, []testTag{sameSize}), false, map[uint16]string{0x010f: "Canon", tagDateTimeOriginal: "2019:05:04 15:14:15"}, true, false},
+		{"other size", testTiff(binary.BigEndian, []testTag{maker}, []testTag{otherSize}), false, map[uint16]string{0x010f: "Canon", tagDateTimeOriginal: "2019:05:04 15:14:15"}, false, false},
+		{"offset added", testTiff(binary.LittleEndian, nil, []testTag{sameSize}), true, map[uint16]string{tagDateTimeOriginal: "2019:05:04 15:14:15", tagOffsetTimeOriginal: "+02:00"}, false, false},
+		{"no exif IFD", testTiff(binary.BigEndian, []testTag{maker}, nil), false, map[uint16]string{0x010f: "Canon", tagDateTimeOriginal: "2019:05:04 15:14:15"}, false, false},
+		{"invalid byte order", []byte("XX\x00\x2a\x00\x00\x00\x08\x00\x00\x00\x00\x00\x00"), false, nil, false, true},
+		{"short header", []byte("MM\x00\x2a"), false, nil, false, true},
+		{"invalid IFD0 offset", []byte("MM\x00\x2a\x00\x00\x01\x00"), false, nil, false, true},
+		{"invalid exif IFD offset", testTiff(binary.BigEndian, []testTag{{tagExifIFDPointer, tiffTypeLong, []byte{0, 0, 0x10, 0}}}, nil), false, nil, false, true},
+		{"invalid value offset", invalidOffset, false, nil, false, true},
+	}
+
+	for _, test := range tests {
+		original := append([]byte{}, test.tiff...)
+		tiff, err := setTiffDate(test.tiff, date, test.withOffset)
+		if !bytes.Equal(test.tiff, original) {
+			t.Errorf("%v: setTiffDate() changed its input", test.name)
+		}
+		if (err != nil) != test.err {
+			t.Errorf("%v: setTiffDate() error = %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+		if tags := testTiffTags(t, tiff); !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("%v: setTiffDate() tags = %q, want %q", test.name, tags, test.tags)
+		}
+		if inPlace := len(tiff) == len(test.tiff); inPlace != test.inPlace {
+			t.Errorf("%v: setTiffDate() in place = %v, want %v", test.name, inPlace, test.inPlace)
+		}
+	}
+}
+
+// testSegment returns a jpg segment of marker with payload.
+func testSegment(marker byte, payload string) []byte {
+	segment := []byte{0xff, marker, 0, 0}
+	binary.BigEndian.PutUint16(segment[2:], uint16(len(payload)+2))
+	return append(segment, payload...)
+}
+
+// testJpegExif returns the tiff of the exif segments of the jpg data, and data
+// without them.
+func testJpegExif(t *testing.T, data []byte) ([][]byte, []byte) {
+	segments, err := jpegSegments(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var tiffs [][]byte
+	rest := append([]byte{}, data[:2]...)
+	for _, segment := range segments {
+		if segment.Marker == 0xe1 && bytes.HasPrefix(segment.Payload, exifHeader) {
+			tiffs = append(tiffs, segment.Payload[len(exifHeader):])
+			continue
+		}
+		rest = append(rest, data[segment.Start:segment.End]...)
+	}
+	return tiffs, rest
+}
+
+func TestSetJpegDate(t *testing.T) {
+	date := time.Date(2019, time.May, 4, 15, 14, 15, 0, time.UTC)
+	soi := []byte{0xff, 0xd8}
+	jfif := testSegment(0xe0, "JFIF\x00\x01\x01")
+	image := bytes.Join([][]byte{testSegment(0xdb, "tables"), testSegment(0xda, "scan"), []byte("pixels\xff\xd9")}, nil)
+	exif := testSegment(0xe1, string(exifHeader)+string(testTiff(binary.BigEndian, []testTag{{0x010f, tiffTypeASCII, []byte("Canon\x00")}}, nil)))
+
+	tests := []struct {
+		name string
+		data []byte
+		tags map[uint16]string
+		err  bool
+	}{
+		{"after JFIF", bytes.Join([][]byte{soi, jfif, image}, nil), map[uint16]string{tagDateTimeOriginal: "2019:05:04 15:14:15"}, false},
+		{"after SOI", bytes.Join([][]byte{soi, image}, nil), map[uint16]string{tagDateTimeOriginal: "2019:05:04 15:14:15"}, false},
+		{"existing exif", bytes.Join([][]byte{soi, jfif, exif, image}, nil), map[uint16]string{0x010f: "Canon", tagDateTimeOriginal: "2019:05:04 15:14:15"}, false},
+		{"not a jpg", []byte("GIF89a"), nil, true},
+		{"invalid segment", bytes.Join([][]byte{soi, jfif[:6]}, nil), nil, true},
+		{"invalid marker", bytes.Join([][]byte{soi, []byte("xx"), image}, nil), nil, true},
+	}
+
+	for _, test := range tests {
+		data, err := setJpegDate(test.data, date, false)
+		if (err != nil) != test.err {
+			t.Errorf("%v: setJpegDate() error = %v, want error %v", test.name, err, test.err)
+			continue
+		}
+		if test.err {
+			continue
+		}
+
+		tiffs, rest := testJpegExif(t, data)
+		if len(tiffs) != 1 {
+			t.Errorf("%v: setJpegDate() has %v exif segments, want 1", test.name, len(tiffs))
+			continue
+		}
+		if tags := testTiffTags(t, tiffs[0]); !reflect.DeepEqual(tags, test.tags) {
+			t.Errorf("%v: setJpegDate() tags = %q, want %q", test.name, tags, test.tags)
+		}
+		if _, original := testJpegExif(t, test.data); !bytes.Equal(rest, original) {
+			t.Errorf("%v: setJpegDate() changed the segments other than exif", test.name)
+		}
+		if jfifAt := bytes.Index(data, jfif); jfifAt >= 0 && jfifAt != 2 {
+			t.Errorf("%v: setJpegDate() moved JFIF to %v", test.name, jfifAt)
+		}
+	}
+}
@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	"github.com/mateusbraga/tools/executil"
+	"github.com/mateusbraga/tools/pdfutil"
 )
 
 var filetypesSupported = map[string]bool{
@@ -19,6 +20,10 @@ var filetypesSupported = map[string]bool{
 	".pdf": true,
 }
 
+var (
+	inputPassword string // password to open encrypted input pdfs
+)
+
 func CallConvert(inputFile string, outputFile string) {
 	convert := exec.Command("convert", inputFile, outputFile)
 	executil.MustRun(convert)
@@ -82,7 +87,7 @@ func MakePdf(files []string, outputFile string) {
 			log.Printf("Derived '%v' from '%v'", tempFile, path)
 			inputFiles = append(inputFiles, tempFile)
 		case ".pdf":
-			inputFiles = append(inputFiles, path)
+			inputFiles = append(inputFiles, decryptInput(path, tempDir))
 		}
 	}
 
@@ -96,6 +101,140 @@ func MakePdf(files []string, outputFile string) {
 	}
 }
 
+// MakeDuplexPdf interleaves the pages of frontsFile and backsFile into
+// outputFile. backsFile is expected in reverse order, as produced by scanning
+// the flipped stack of paper on a single-sided feeder.
+func MakeDuplexPdf(frontsFile string, backsFile string, outputFile string) {
+	err := executil.HasExecutables("gs")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	tempDir, err := ioutil.TempDir("", "gomakepdf")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Check
+	for _, file := range []string{frontsFile, backsFile} {
+		if ext := filepath.Ext(file); strings.ToLower(ext) != ".pdf" {
+			log.Fatalf("duplex input must be a pdf file: '%v'\n", file)
+		}
+	}
+	if ext := filepath.Ext(outputFile); ext != ".pdf" {
+		log.Fatalf("outputFile must be a pdf file: '%v'\n", outputFile)
+	}
+
+	// Split in pages
+	frontsDir := filepath.Join(tempDir, "fronts")
+	backsDir := filepath.Join(tempDir, "backs")
+	for _, dir := range []string{frontsDir, backsDir} {
+		if err := os.Mkdir(dir, 0700); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	fronts, err := pdfutil.Burst(decryptInput(frontsFile, frontsDir), frontsDir)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	backs, err := pdfutil.Burst(decryptInput(backsFile, backsDir), backsDir)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(fronts) != len(backs) {
+		log.Fatalf("'%v' has %v pages but '%v' has %v pages\n", frontsFile, len(fronts), backsFile, len(backs))
+	}
+
+	// Interleave: front 1, last back, front 2, second to last back, ...
+	var orderedFiles []string
+	for i := range fronts {
+		orderedFiles = append(orderedFiles, fronts[i], backs[len(backs)-1-i])
+	}
+
+	// Create pdf
+	CallGhostScript(outputFile, orderedFiles)
+
+	log.Printf("Done generating '%v' from '%v' and '%v' (%v pages)", outputFile, frontsFile, backsFile, len(orderedFiles))
+}
+
+// decryptInput returns a decrypted copy of pdfFile inside tempDir, or pdfFile
+// itself if no inputPassword was given.
+func decryptInput(pdfFile string, tempDir string) string {
+	if inputPassword == "" {
+		return pdfFile
+	}
+
+	tempFile, err := ioutil.TempFile(tempDir, "decrypted*.pdf")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	tempFile.Close()
+
+	err = pdfutil.Decrypt(pdfFile, tempFile.Name(), inputPassword)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	log.Printf("Decrypted '%v'", pdfFile)
+	return tempFile.Name()
+}
+
+// RemoveBlankPages removes the pages of pdfFile with at most threshold percent of ink.
+func RemoveBlankPages(pdfFile string, threshold float64) {
+	blankPages, coverage, err := pdfutil.BlankPages(pdfFile, pdfutil.DEFAULT_BLANK_DPI, threshold)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	switch {
+	case len(blankPages) == 0:
+		log.Printf("No blank pages found in '%v'", pdfFile)
+		return
+	case len(blankPages) == len(coverage):
+		log.Printf("All %v pages of '%v' are blank, keeping them", len(coverage), pdfFile)
+		return
+	}
+
+	tempFile := pdfFile[:len(pdfFile)-len(".pdf")] + ".without_blanks.pdf"
+	err = pdfutil.RemovePages(pdfFile, len(coverage), blankPages, tempFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	CallMove(tempFile, pdfFile)
+
+	log.Printf("Removed blank pages %v of '%v'", blankPages, pdfFile)
+}
+
+// MakePdfA converts pdfFile to PDF/A of the given level and reports why it is
+// not compliant, if that is the case.
+func MakePdfA(pdfFile string, level string, iccProfile string) {
+	err := pdfutil.ConvertToPdfAInPlace(pdfFile, level, iccProfile, false)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	problems, validated, err := pdfutil.VerifyPdfA(pdfFile, level)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	switch {
+	case len(problems) > 0 && validated:
+		log.Printf("'%v' is not PDF/A-%v compliant:", pdfFile, level)
+	case len(problems) > 0:
+		log.Printf("'%v' may not be PDF/A-%v compliant (basic checks, install veraPDF to validate it):", pdfFile, level)
+	case validated:
+		log.Printf("'%v' is PDF/A-%v compliant", pdfFile, level)
+	default:
+		log.Printf("'%v' passed the basic PDF/A-%v checks, install veraPDF to validate it", pdfFile, level)
+	}
+	for _, problem := range problems {
+		log.Printf("\t%v", problem)
+	}
+}
+
 func isFiletypeSupported(filename string) bool {
 	ext := filepath.Ext(filename)
 	return !filetypesSupported[strings.ToLower(ext)]
@@ -103,16 +242,85 @@ func isFiletypeSupported(filename string) bool {
 
 func main() {
 	finalOutputFile := flag.String("output", "output.pdf", "Set the pdf output file to be created")
+	duplexFlag := flag.Bool("duplex", false, "Interleave a pdf of fronts with a pdf of backs scanned in reverse order")
+	removeBlankFlag := flag.Bool("remove-blank", false, "Remove blank pages from the pdf output file")
+	blankThresholdFlag := flag.Float64("blank-threshold", pdfutil.DEFAULT_BLANK_THRESHOLD, "Maximum percentage of ink for a page to be considered blank")
+	pdfaFlag := flag.String("pdfa", "", "Write a PDF/A output file of this level: 1b or 2b")
+	iccProfileFlag := flag.String("icc-profile", "", "sRGB icc profile used as PDF/A output intent (default: the one shipped with ghostscript)")
+	passwordFlag, encryptOptions := pdfutil.PasswordFlags()
+	stampOptions := pdfutil.StampFlags()
 	flag.Parse()
 
-	var inputFiles []string
-	if len(flag.Args()) > 0 {
-		inputFiles = flag.Args()
+	inputPassword = *passwordFlag
+	if err := encryptOptions.Check(); err != nil {
+		log.Fatalln(err)
+	}
+	if inputPassword != "" || encryptOptions.Enabled() {
+		if err := executil.HasExecutables("qpdf"); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if stampOptions.Enabled() {
+		if err := stampOptions.Check(); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *pdfaFlag != "" {
+		if err := pdfutil.CheckPdfALevel(*pdfaFlag); err != nil {
+			log.Fatalln(err)
+		}
+		if encryptOptions.Enabled() {
+			log.Fatalln("PDF/A documents can not be encrypted")
+		}
+		if *iccProfileFlag == "" {
+			iccProfile, err := pdfutil.FindIccProfile()
+			if err != nil {
+				log.Fatalln(err)
+			}
+			*iccProfileFlag = iccProfile
+		}
+	}
+
+	if *duplexFlag {
+		if len(flag.Args()) != 2 {
+			fmt.Println("You need to give the fronts and the backs pdf files.\n\t Example: newpdf --duplex fronts.pdf backs.pdf")
+			return
+		}
+
+		log.Printf("Interleave %v and %v into %v", flag.Arg(0), flag.Arg(1), *finalOutputFile)
+		MakeDuplexPdf(flag.Arg(0), flag.Arg(1), *finalOutputFile)
 	} else {
-		fmt.Println("You need to give the list of files to be merged.\n\t Example: newpdf page1.jpg page2.jpg others.pdf")
-		return
+		var inputFiles []string
+		if len(flag.Args()) > 0 {
+			inputFiles = flag.Args()
+		} else {
+			fmt.Println("You need to give the list of files to be merged.\n\t Example: newpdf page1.jpg page2.jpg others.pdf")
+			return
+		}
+
+		log.Printf("Merge %v files into %v", len(inputFiles), *finalOutputFile)
+		MakePdf(inputFiles, *finalOutputFile)
+	}
+
+	if *removeBlankFlag {
+		RemoveBlankPages(*finalOutputFile, *blankThresholdFlag)
+	}
+
+	if stampOptions.Enabled() {
+		if _, err := pdfutil.StampInPlace(*finalOutputFile, *stampOptions); err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Stamped '%v'", *finalOutputFile)
+	}
+
+	if *pdfaFlag != "" {
+		MakePdfA(*finalOutputFile, *pdfaFlag, *iccProfileFlag)
 	}
 
-	log.Printf("Merge %v files into %v", len(inputFiles), *finalOutputFile)
-	MakePdf(inputFiles, *finalOutputFile)
+	if encryptOptions.Enabled() {
+		if err := pdfutil.EncryptInPlace(*finalOutputFile, *encryptOptions); err != nil {
+			log.Fatalln(err)
+		}
+		log.Printf("Encrypted '%v'", *finalOutputFile)
+	}
 }
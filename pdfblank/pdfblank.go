@@ -0,0 +1,65 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/mateusbraga/tools/executil"
+	"github.com/mateusbraga/tools/pdfutil"
+)
+
+func main() {
+	thresholdFlag := flag.Float64("threshold", pdfutil.DEFAULT_BLANK_THRESHOLD, "Maximum percentage of ink for a page to be considered blank")
+	dpiFlag := flag.Int("dpi", pdfutil.DEFAULT_BLANK_DPI, "Resolution used to render the pages")
+	reportFlag := flag.Bool("report", false, "Only report the blank pages, do not remove them")
+	outputFlag := flag.String("output", "", "Set the pdf output file to be created (default: 'file - without blanks.pdf')")
+	flag.Parse()
+
+	if len(flag.Args()) != 1 {
+		fmt.Println("Usage: pdfblank [--report] [--threshold 0.5] file.pdf")
+		os.Exit(1)
+	}
+	inputFile := flag.Arg(0)
+
+	err := executil.HasExecutables("gs")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	blankPages, coverage, err := pdfutil.BlankPages(inputFile, *dpiFlag, *thresholdFlag)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	for _, page := range blankPages {
+		fmt.Printf("\tPage %v of '%v' is blank (%.2f%% ink)\n", page, inputFile, coverage[page-1])
+	}
+
+	if *reportFlag {
+		fmt.Printf("Found %v blank pages in '%v' (%v pages)\n", len(blankPages), inputFile, len(coverage))
+		return
+	}
+
+	if len(blankPages) == 0 {
+		fmt.Printf("No blank pages found in '%v'\n", inputFile)
+		return
+	}
+	if len(blankPages) == len(coverage) {
+		fmt.Printf("All %v pages of '%v' are blank, nothing to write\n", len(coverage), inputFile)
+		os.Exit(1)
+	}
+
+	outputFile := *outputFlag
+	if outputFile == "" {
+		outputFile = inputFile[:len(inputFile)-len(".pdf")] + " - without blanks.pdf"
+	}
+
+	err = pdfutil.RemovePages(inputFile, len(coverage), blankPages, outputFile)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	fmt.Printf("Removed %v blank pages of '%v'. '%v' created.\n", len(blankPages), inputFile, outputFile)
+}
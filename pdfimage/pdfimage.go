@@ -0,0 +1,205 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/mateusbraga/tools/executil"
+	"github.com/mateusbraga/tools/pdfutil"
+)
+
+// ghostscript raster devices for each supported image format
+var formatDevices = map[string]string{
+	"png":  "png16m",
+	"jpeg": "jpeg",
+	"jpg":  "jpeg",
+	"tiff": "tiff24nc",
+}
+
+var (
+	renderWorkerTotal = runtime.NumCPU() // Number of concurrent ghostscript processes
+)
+
+var (
+	renderWorkerWaitGroup sync.WaitGroup
+)
+
+// renderSettings holds the settings shared by every page range being rendered.
+type renderSettings struct {
+	InputFile    string
+	OutputDir    string
+	NameTemplate string
+	Format       string
+	Dpi          int
+	JpegQuality  int
+	PageDigits   int // page numbers are zero padded to this width
+	TempDir      string
+}
+
+func main() {
+	formatFlag := flag.String("format", "png", "Image format: png, jpeg or tiff")
+	dpiFlag := flag.Int("dpi", 150, "Resolution of the images")
+	pagesFlag := flag.String("pages", "", "Pages to export, e.g. 1-3,7,10- (default: all pages)")
+	nameFlag := flag.String("name", "{name}-{page}.{ext}", "Name of the images. Placeholders: {name}, {page}, {ext}")
+	outputDirFlag := flag.String("output-dir", ".", "Directory where the images are written")
+	jpegQualityFlag := flag.Int("jpeg-quality", 90, "Quality (1-100) of jpeg images")
+	flag.Parse()
+
+	if len(flag.Args()) != 1 {
+		fmt.Println("Usage: pdfimage [--format png] [--dpi 150] [--pages 1-3,7] file.pdf")
+		os.Exit(1)
+	}
+	inputFile := flag.Arg(0)
+
+	format := strings.ToLower(*formatFlag)
+	if _, ok := formatDevices[format]; !ok {
+		fmt.Printf("Unknown format '%v'. Use png, jpeg or tiff.\n", *formatFlag)
+		os.Exit(1)
+	}
+	if !strings.Contains(*nameFlag, "{page}") {
+		fmt.Println("The --name template must contain {page}.")
+		os.Exit(1)
+	}
+
+	err := executil.HasExecutables("gs")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	numberOfPages, err := pdfutil.NumberOfPages(inputFile)
+	if err != nil {
+		log.Fatalln("Failed to get number of pages:", err)
+	}
+
+	pages, err := pdfutil.ParsePages(*pagesFlag, numberOfPages)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if err := os.MkdirAll(*outputDirFlag, 0755); err != nil {
+		log.Fatalln(err)
+	}
+
+	tempDir, err := ioutil.TempDir("", "gopdfimage")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	settings := renderSettings{
+		InputFile:    inputFile,
+		OutputDir:    *outputDirFlag,
+		NameTemplate: *nameFlag,
+		Format:       format,
+		Dpi:          *dpiFlag,
+		JpegQuality:  *jpegQualityFlag,
+		PageDigits:   len(strconv.Itoa(numberOfPages)),
+		TempDir:      tempDir,
+	}
+
+	// Spread the pages between the workers
+	pagesPerRange := (len(pages) + renderWorkerTotal - 1) / renderWorkerTotal
+	ranges := pdfutil.PageRanges(pages, pagesPerRange)
+
+	log.Printf("Rendering %v pages of '%v' as %v at %v dpi\n", len(pages), inputFile, format, *dpiFlag)
+
+	rangesCh := make(chan pdfutil.PageRange)
+	errs := make(chan error, len(ranges))
+	renderWorkerWaitGroup.Add(renderWorkerTotal)
+	for i := 0; i < renderWorkerTotal; i++ {
+		go renderWorker(rangesCh, errs, settings)
+	}
+	for _, r := range ranges {
+		rangesCh <- r
+	}
+	close(rangesCh)
+
+	renderWorkerWaitGroup.Wait()
+	close(errs)
+
+	failed := 0
+	for err := range errs {
+		log.Println(err)
+		failed++
+	}
+	if failed > 0 {
+		os.RemoveAll(tempDir)
+		log.Fatalf("Failed to render %v of %v page ranges\n", failed, len(ranges))
+	}
+	log.Printf("Done\n")
+}
+
+func renderWorker(ranges <-chan pdfutil.PageRange, errs chan<- error, settings renderSettings) {
+	defer renderWorkerWaitGroup.Done()
+
+	for r := range ranges {
+		if err := renderPageRange(r, settings); err != nil {
+			errs <- fmt.Errorf("pages %v-%v: %v", r.First, r.Last, err)
+		}
+	}
+}
+
+// renderPageRange renders the pages of r and moves them to their final names.
+func renderPageRange(r pdfutil.PageRange, settings renderSettings) error {
+	ext := settings.Format
+	if ext == "jpeg" {
+		ext = "jpg"
+	}
+
+	// ghostscript numbers the images from 1, starting at r.First
+	tempPattern := filepath.Join(settings.TempDir, fmt.Sprintf("range%06d_%%06d.%v", r.First, ext))
+	err := renderUsingGhostScript(settings, r.First, r.Last, tempPattern)
+	if err != nil {
+		return err
+	}
+
+	for page := r.First; page <= r.Last; page++ {
+		tempFile := fmt.Sprintf(tempPattern, page-r.First+1)
+		outputFile := filepath.Join(settings.OutputDir, imageName(settings, page, ext))
+
+		if err := os.Rename(tempFile, outputFile); err != nil {
+			return err
+		}
+		log.Printf("\tPage %v -> '%v'\n", page, outputFile)
+	}
+	return nil
+}
+
+func imageName(settings renderSettings, page int, ext string) string {
+	name := filepath.Base(settings.InputFile)
+	name = name[:len(name)-len(filepath.Ext(name))]
+
+	r := strings.NewReplacer(
+		"{name}", name,
+		"{page}", fmt.Sprintf("%0*d", settings.PageDigits, page),
+		"{ext}", ext,
+	)
+	return r.Replace(settings.NameTemplate)
+}
+
+func renderUsingGhostScript(settings renderSettings, initialPage, lastPage int, outputPattern string) error {
+	// gs -sDEVICE=png16m -r150 -dNOPAUSE -dBATCH -dSAFER -dFirstPage=1 -dLastPage=4 -sOutputFile=page%06d.png input.pdf
+	deviceArg := fmt.Sprintf("-sDEVICE=%v", formatDevices[settings.Format])
+	resolutionArg := fmt.Sprintf("-r%d", settings.Dpi)
+	initialPageArg := fmt.Sprintf("-dFirstPage=%d", initialPage)
+	lastPageArg := fmt.Sprintf("-dLastPage=%d", lastPage)
+	outputFileArg := fmt.Sprintf("-sOutputFile=%v", outputPattern)
+	args := []string{deviceArg, resolutionArg, "-dNOPAUSE", "-dBATCH", "-dSAFER", "-dQUIET", "-dTextAlphaBits=4", "-dGraphicsAlphaBits=4"}
+	if formatDevices[settings.Format] == "jpeg" {
+		args = append(args, fmt.Sprintf("-dJPEGQ=%d", settings.JpegQuality))
+	}
+	args = append(args, initialPageArg, lastPageArg, outputFileArg, settings.InputFile)
+
+	gs := exec.Command("gs", args...)
+	_, err := executil.RunWithVerboseError(gs)
+	return err
+}
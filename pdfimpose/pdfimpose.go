@@ -0,0 +1,225 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/mateusbraga/tools/executil"
+	"github.com/mateusbraga/tools/pdfutil"
+)
+
+// sheetSizes holds the portrait size of the supported sheets, in points
+var sheetSizes = map[string][2]int{
+	"a3":      {842, 1191},
+	"a4":      {595, 842},
+	"a5":      {420, 595},
+	"letter":  {612, 792},
+	"legal":   {612, 1008},
+	"tabloid": {792, 1224},
+}
+
+// grids holds the columns and rows used for each number of pages per sheet.
+// Sheets are landscape when there are more columns than rows.
+var grids = map[int][2]int{
+	2:  {2, 1},
+	4:  {2, 2},
+	6:  {3, 2},
+	8:  {4, 2},
+	9:  {3, 3},
+	16: {4, 4},
+}
+
+func main() {
+	nupFlag := flag.Int("n", 2, "Pages per sheet: 2, 4, 6, 8, 9 or 16")
+	sheetFlag := flag.String("sheet", "a4", "Sheet size: a3, a4, a5, letter, legal or tabloid")
+	gutterFlag := flag.Float64("gutter", 0, "Space between the pages, in points")
+	orderFlag := flag.String("order", "ltr", "Reading order: ltr (left to right, then down), rtl (right to left, then down) or ttb (top to bottom, then right)")
+	bookletFlag := flag.Bool("booklet", false, "Saddle-stitch booklet: 2 pages per sheet, reordered to be printed double-sided and folded")
+	outputFlag := flag.String("output", "", "Set the pdf output file to be created (default: 'file - imposed.pdf')")
+	flag.Parse()
+
+	if len(flag.Args()) != 1 {
+		fmt.Println("Usage: pdfimpose [--n 4] [--sheet a4] [--gutter 10] [--booklet] file.pdf")
+		os.Exit(1)
+	}
+	inputFile := flag.Arg(0)
+
+	if *bookletFlag {
+		*nupFlag = 2
+		*orderFlag = "ltr"
+	}
+	grid, ok := grids[*nupFlag]
+	if !ok {
+		fmt.Printf("Unsupported number of pages per sheet: %v\n", *nupFlag)
+		os.Exit(1)
+	}
+	sheet, ok := sheetSizes[*sheetFlag]
+	if !ok {
+		fmt.Printf("Unknown sheet size '%v'\n", *sheetFlag)
+		os.Exit(1)
+	}
+	if grid[0] > grid[1] {
+		sheet[0], sheet[1] = sheet[1], sheet[0]
+	}
+
+	// Every page is fitted in its cell, leaving half of the gutter on each side
+	cellWidth := float64(sheet[0]) / float64(grid[0])
+	cellHeight := float64(sheet[1]) / float64(grid[1])
+	if *gutterFlag < 0 || *gutterFlag >= math.Min(cellWidth, cellHeight) {
+		fmt.Printf("Invalid gutter '%v'. It must be from 0 to less than %v points, the smallest side of a page on the sheet.\n", *gutterFlag, int(math.Min(cellWidth, cellHeight)))
+		os.Exit(1)
+	}
+
+	outputFile := *outputFlag
+	if outputFile == "" {
+		outputFile = inputFile[:len(inputFile)-len(filepath.Ext(inputFile))] + " - imposed.pdf"
+		if *bookletFlag {
+			outputFile = inputFile[:len(inputFile)-len(filepath.Ext(inputFile))] + " - booklet.pdf"
+		}
+	}
+
+	err := executil.HasExecutables("gs")
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	numberOfPages, err := pdfutil.NumberOfPages(inputFile)
+	if err != nil {
+		log.Fatalln("Failed to get number of pages:", err)
+	}
+
+	var order []int
+	if *bookletFlag {
+		order = bookletOrder(numberOfPages)
+	} else {
+		order, err = readingOrder(numberOfPages, grid[0], grid[1], *orderFlag)
+		if err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	tempDir, err := ioutil.TempDir("", "gopdfimpose")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	defer os.RemoveAll(tempDir)
+
+	// Reorder and pad the pages
+	reorderedFile := filepath.Join(tempDir, "reordered.pdf")
+	if err := pdfutil.Reorder(inputFile, reorderedFile, order); err != nil {
+		log.Fatalln(err)
+	}
+
+	// Fit every page in its cell
+	fittedFile := filepath.Join(tempDir, "fitted.pdf")
+	err = fitPagesUsingGhostScript(reorderedFile, fittedFile, cellWidth-*gutterFlag, cellHeight-*gutterFlag)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	cellsFile := filepath.Join(tempDir, "cells.pdf")
+	err = addMarginUsingGhostScript(fittedFile, cellsFile, cellWidth, cellHeight, *gutterFlag/2)
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	// Place the cells on the sheets
+	err = nupUsingGhostScript(cellsFile, outputFile, grid[0], grid[1])
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	log.Printf("Done generating '%v' from '%v': %v pages on %v sheet sides\n", outputFile, inputFile, numberOfPages, len(order) / *nupFlag)
+}
+
+// readingOrder returns the pages in the order they must be given to
+// ghostscript, which fills each sheet left to right and then top to bottom,
+// to be read in the given order. The last sheet is padded with blank pages (0).
+func readingOrder(numberOfPages int, columns int, rows int, order string) ([]int, error) {
+	perSheet := columns * rows
+	numberOfSheets := (numberOfPages + perSheet - 1) / perSheet
+
+	var pages []int
+	for sheet := 0; sheet < numberOfSheets; sheet++ {
+		for row := 0; row < rows; row++ {
+			for column := 0; column < columns; column++ {
+				var index int
+				switch order {
+				case "ltr":
+					index = row*columns + column
+				case "rtl":
+					index = row*columns + (columns - 1 - column)
+				case "ttb":
+					index = column*rows + row
+				default:
+					return nil, fmt.Errorf("unknown reading order '%v'", order)
+				}
+
+				page := sheet*perSheet + index + 1
+				if page > numberOfPages {
+					page = 0
+				}
+				pages = append(pages, page)
+			}
+		}
+	}
+	return pages, nil
+}
+
+// bookletOrder returns the pages, padded with blank pages (0) to a multiple of
+// 4, in the order to print them 2 per sheet side for a saddle-stitch booklet.
+func bookletOrder(numberOfPages int) []int {
+	n := (numberOfPages + 3) / 4 * 4
+
+	page := func(p int) int {
+		if p > numberOfPages {
+			return 0
+		}
+		return p
+	}
+
+	var pages []int
+	for i := 0; i < n/4; i++ {
+		// front: last and first pages, back: second and second to last pages
+		pages = append(pages, page(n-2*i), page(2*i+1), page(2*i+2), page(n-2*i-1))
+	}
+	return pages
+}
+
+func fitPagesUsingGhostScript(inputFile string, outputFile string, width float64, height float64) error {
+	// gs -sDEVICE=pdfwrite -dFIXEDMEDIA -dPDFFitPage -dDEVICEWIDTHPOINTS=297 -dDEVICEHEIGHTPOINTS=420 -o output.pdf input.pdf
+	widthArg := fmt.Sprintf("-dDEVICEWIDTHPOINTS=%d", int(width))
+	heightArg := fmt.Sprintf("-dDEVICEHEIGHTPOINTS=%d", int(height))
+	args := []string{"-o", outputFile, "-sDEVICE=pdfwrite", "-dFIXEDMEDIA", "-dPDFFitPage", widthArg, heightArg, inputFile}
+
+	gs := exec.Command("gs", args...)
+	_, err := executil.RunWithVerboseError(gs)
+	return err
+}
+
+func addMarginUsingGhostScript(inputFile string, outputFile string, width float64, height float64, margin float64) error {
+	// gs -sDEVICE=pdfwrite -dFIXEDMEDIA -dDEVICEWIDTHPOINTS=297 -dDEVICEHEIGHTPOINTS=420 -o output.pdf -c "<</BeginPage {pop 5 5 translate}>> setpagedevice" -f input.pdf
+	widthArg := fmt.Sprintf("-dDEVICEWIDTHPOINTS=%d", int(width))
+	heightArg := fmt.Sprintf("-dDEVICEHEIGHTPOINTS=%d", int(height))
+	beginPage := fmt.Sprintf("<</BeginPage {pop %v %v translate}>> setpagedevice", margin, margin)
+	args := []string{"-o", outputFile, "-sDEVICE=pdfwrite", "-dFIXEDMEDIA", widthArg, heightArg, "-c", beginPage, "-f", inputFile}
+
+	gs := exec.Command("gs", args...)
+	_, err := executil.RunWithVerboseError(gs)
+	return err
+}
+
+func nupUsingGhostScript(inputFile string, outputFile string, columns int, rows int) error {
+	// gs -sDEVICE=pdfwrite -sNupControl=2x1 -o output.pdf input.pdf
+	nupArg := fmt.Sprintf("-sNupControl=%dx%d", columns, rows)
+	args := []string{"-o", outputFile, "-sDEVICE=pdfwrite", nupArg, inputFile}
+
+	gs := exec.Command("gs", args...)
+	_, err := executil.RunWithVerboseError(gs)
+	return err
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestBookletOrder(t *testing.T) {
+	tests := []struct {
+		numberOfPages int
+		pages         []int
+	}{
+		{0, nil},
+		{1, []int{0, 1, 0, 0}},
+		{4, []int{4, 1, 2, 3}},
+		{5, []int{0, 1, 2, 0, 0, 3, 4, 5}},
+		{8, []int{8, 1, 2, 7, 6, 3, 4, 5}},
+		{10, []int{0, 1, 2, 0, 10, 3, 4, 9, 8, 5, 6, 7}},
+	}
+
+	for _, test := range tests {
+		pages := bookletOrder(test.numberOfPages)
+		if !reflect.DeepEqual(pages, test.pages) {
+			t.Errorf("bookletOrder(%v) = %v, want %v", test.numberOfPages, pages, test.pages)
+		}
+	}
+}
+
+func TestBookletOrderHasEveryPageOnce(t *testing.T) {
+	for numberOfPages := 1; numberOfPages <= 33; numberOfPages++ {
+		pages := bookletOrder(numberOfPages)
+		if len(pages)%4 != 0 {
+			t.Errorf("bookletOrder(%v) has %v pages, not a multiple of 4", numberOfPages, len(pages))
+		}
+
+		seen := make(map[int]bool)
+		for _, page := range pages {
+			if page == 0 {
+				continue
+			}
+			if page < 0 || page > numberOfPages || seen[page] {
+				t.Errorf("bookletOrder(%v) = %v, page %v is invalid or repeated", numberOfPages, pages, page)
+			}
+			seen[page] = true
+		}
+		if len(seen) != numberOfPages {
+			t.Errorf("bookletOrder(%v) = %v, has %v of the pages", numberOfPages, pages, len(seen))
+		}
+	}
+}
+
+func TestReadingOrder(t *testing.T) {
+	tests := []struct {
+		numberOfPages int
+		columns       int
+		rows          int
+		order         string
+		pages         []int
+		err           bool
+	}{
+		{4, 2, 1, "ltr", []int{1, 2, 3, 4}, false},
+		{3, 2, 1, "ltr", []int{1, 2, 3, 0}, false},
+		{4, 2, 1, "rtl", []int{2, 1, 4, 3}, false},
+		{4, 2, 2, "ttb", []int{1, 3, 2, 4}, false},
+		{5, 2, 2, "ttb", []int{1, 3, 2, 4, 5, 0, 0, 0}, false},
+		{6, 3, 2, "rtl", []int{3, 2, 1, 6, 5, 4}, false},
+		{4, 2, 1, "btt", nil, true},
+	}
+
+	for _, test := range tests {
+		pages, err := readingOrder(test.numberOfPages, test.columns, test.rows, test.order)
+		if (err != nil) != test.err {
+			t.Errorf("readingOrder(%v, %v, %v, %v) error = %v, want error %v", test.numberOfPages, test.columns, test.rows, test.order, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(pages, test.pages) {
+			t.Errorf("readingOrder(%v, %v, %v, %v) = %v, want %v", test.numberOfPages, test.columns, test.rows, test.order, pages, test.pages)
+		}
+	}
+}
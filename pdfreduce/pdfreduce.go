@@ -1,89 +1,771 @@
 package main
 
 import (
+	"encoding/json"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
+	"io/ioutil"
 	"math"
 	"os"
 	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
 
 	"github.com/mateusbraga/tools/executil"
+	"github.com/mateusbraga/tools/pdfutil"
 )
 
 const (
-	// Expects at least a 10% reduction of the filesize
+	// By default, expects at least a 10% reduction of the filesize (see --min-savings)
 	MINIMUM_FILESIZE_REDUCTION_EXPECTED = 0.1
+
+	backupCopyExtension = ".backup"
+)
+
+var (
+	reduceWorkerTotal = runtime.NumCPU() // Number of concurrent pdfs being reduced
 )
 
+var (
+	reduceWorkerWaitGroup sync.WaitGroup
+
+	// humanOutput receives the progress messages. It is stderr in --json mode
+	// so that stdout only has the json results.
+	humanOutput io.Writer = os.Stdout
+)
+
+// options holds the settings shared by every file being reduced.
+type options struct {
+	Profile    profile
+	TargetSize int64
+	Override   func(profile) profile
+	InPlace    bool
+	OutputDir  string
+	MinSavings float64 // minimum reduction expected, from 0 to 1
+	Password   string  // password to open encrypted inputs
+	Encrypt    pdfutil.EncryptOptions
+	PdfA       string // PDF/A level of the outputs, empty for regular pdfs
+	IccProfile string // output intent of PDF/A outputs
+}
+
+// pdfFile is a pdf to reduce.
+type pdfFile struct {
+	Path string // absolute
+	Rel  string // path relative to the walked dir, or the base name of files given directly
+}
+
+// result describes the outcome of reducing one file.
+type result struct {
+	InputFile    string
+	OutputFile   string
+	OriginalSize int64
+	NewSize      int64
+	Profile      string
+	Duration     time.Duration
+	PdfAProblems []string // found by veraPDF
+	PdfAWarnings []string // found by the basic checks, without veraPDF
+	Skipped      bool
+	Err          error
+}
+
+// jsonResult is the --json representation of a result.
+type jsonResult struct {
+	InputFile      string   `json:"input"`
+	OutputFile     string   `json:"output,omitempty"`
+	OriginalSize   int64    `json:"original_size"`
+	NewSize        int64    `json:"new_size,omitempty"`
+	Ratio          float64  `json:"ratio,omitempty"`
+	SavingsPercent float64  `json:"savings_percent,omitempty"`
+	Profile        string   `json:"profile,omitempty"`
+	DurationMs     int64    `json:"duration_ms"`
+	PdfA           string   `json:"pdfa,omitempty"`
+	PdfAProblems   []string `json:"pdfa_problems,omitempty"`
+	PdfAWarnings   []string `json:"pdfa_warnings,omitempty"`
+	Skipped        bool     `json:"skipped"`
+	Error          string   `json:"error,omitempty"`
+}
+
+func (r result) ratio() float64 {
+	if r.OriginalSize == 0 {
+		return 0
+	}
+	return float64(r.NewSize) / float64(r.OriginalSize)
+}
+
+// savingsPercentage returns how much smaller the new file is, e.g. 40 for a 40% saving.
+func (r result) savingsPercentage() float64 {
+	if r.OriginalSize == 0 {
+		return 0
+	}
+	return 100 * (1 - r.ratio())
+}
+
+func (r result) toJson(pdfa string) jsonResult {
+	jr := jsonResult{
+		InputFile:    r.InputFile,
+		OriginalSize: r.OriginalSize,
+		Profile:      r.Profile,
+		DurationMs:   int64(r.Duration / time.Millisecond),
+		Skipped:      r.Skipped,
+	}
+	if !r.Skipped {
+		jr.PdfA = pdfa
+		jr.PdfAProblems = r.PdfAProblems
+		jr.PdfAWarnings = r.PdfAWarnings
+	}
+	if r.Err != nil {
+		jr.Error = r.Err.Error()
+		return jr
+	}
+	if r.NewSize > 0 {
+		jr.NewSize = r.NewSize
+		jr.Ratio = r.ratio()
+		jr.SavingsPercent = r.savingsPercentage()
+	}
+	if !r.Skipped {
+		jr.OutputFile = r.OutputFile
+	}
+	return jr
+}
+
 func main() {
-	maxFlag := flag.Bool("max", false, "Try to reduce size to the maximum")
+	maxFlag := flag.Bool("max", false, "Try to reduce size to the maximum (same as --profile screen)")
+	profileFlag := flag.String("profile", "ebook", "Quality profile: screen, ebook, printer, prepress or custom")
+	dpiFlag := flag.Int("dpi", 0, "Downsample color and gray images to this resolution")
+	jpegQualityFlag := flag.Int("jpeg-quality", 0, "Recompress color and gray images as jpeg with this quality (1-100)")
+	grayscaleFlag := flag.Bool("grayscale", false, "Convert colors to grayscale")
+	subsetFontsFlag := flag.Bool("subset-fonts", true, "Embed only the used glyphs of the fonts")
+	targetSizeFlag := flag.String("target-size", "", "Try progressively stronger settings until the output fits this size, in decimal units, e.g. 5M for 5000000 bytes")
+	inPlaceFlag := flag.Bool("in-place", false, "Replace the input files, keeping a '"+backupCopyExtension+"' copy of the originals. Files with a backup already are not replaced")
+	outputDirFlag := flag.String("output-dir", "", "Write the reduced files to this directory")
+	minSavingsFlag := flag.Float64("min-savings", 100*MINIMUM_FILESIZE_REDUCTION_EXPECTED, "Discard outputs that are not at least this percentage smaller")
+	jsonFlag := flag.Bool("json", false, "Print one json object per file instead of the summary table")
+	pdfaFlag := flag.String("pdfa", "", "Write PDF/A output files of this level: 1b or 2b")
+	iccProfileFlag := flag.String("icc-profile", "", "sRGB icc profile used as PDF/A output intent (default: the one shipped with ghostscript)")
+	passwordFlag, encryptOptions := pdfutil.PasswordFlags()
 	flag.Parse()
 
-	if len(flag.Args()) != 1 {
-		fmt.Printf("Usage: reducepdf file.pdf %v \n", flag.Args())
+	if len(flag.Args()) == 0 {
+		fmt.Println("Usage: pdfreduce [flags] file.pdf|dir|./... ...")
+		os.Exit(1)
+	}
+	if *inPlaceFlag && *outputDirFlag != "" {
+		fmt.Println("--in-place and --output-dir can not be used together.")
 		os.Exit(1)
 	}
-	inputFile := flag.Arg(0)
 
-	inputFileinfo, err := os.Stat(inputFile)
-	if err != nil {
-		fmt.Printf("Could not stat input file %v: %v", inputFile, err)
+	if *minSavingsFlag < 0 || *minSavingsFlag >= 100 {
+		fmt.Printf("Invalid minimum savings '%v'. It must be a percentage from 0 to 100.\n", *minSavingsFlag)
 		os.Exit(1)
 	}
+	if *jsonFlag {
+		humanOutput = os.Stderr
+	}
 
-	outputFile := inputFile[:len(inputFile)-len(".pdf")] + " - compressed.pdf"
 	if *maxFlag {
-		outputFile = inputFile[:len(inputFile)-len(".pdf")] + " - highly compressed.pdf"
+		*profileFlag = "screen"
+	}
+	selectedProfile, ok := profiles[*profileFlag]
+	if !ok {
+		fmt.Printf("Unknown profile '%v'. Use screen, ebook, printer, prepress or custom.\n", *profileFlag)
+		os.Exit(1)
 	}
 
-	reducePdfSizeUsingGhostScript(inputFile, outputFile, *maxFlag)
+	var targetSize int64
+	if *targetSizeFlag != "" {
+		targetSizeInBytes, err := parseSize(*targetSizeFlag)
+		if err != nil || targetSizeInBytes == 0 {
+			fmt.Printf("Invalid target size '%v'.\n\t Example: pdfreduce --target-size 5M file.pdf\n", *targetSizeFlag)
+			os.Exit(1)
+		}
+		targetSize = targetSizeInBytes
 
-	outputFileinfo, err := os.Stat(outputFile)
-	if err != nil {
-		fmt.Printf("BUG: could not stat output file %v: %v", outputFile, err)
+		// the profiles are tried from the mildest until the output fits
+		flag.Visit(func(f *flag.Flag) {
+			if f.Name == "profile" || f.Name == "max" {
+				fmt.Printf("--%v can not be used with --target-size, which picks the profile.\n", f.Name)
+				os.Exit(1)
+			}
+		})
+	}
+
+	// Settings given explicitly override the ones of the profile. In target
+	// size mode, image settings are left to the progressively stronger profiles.
+	override := func(p profile) profile {
+		flag.Visit(func(f *flag.Flag) {
+			switch f.Name {
+			case "dpi":
+				if targetSize == 0 {
+					p.ImageDpi = *dpiFlag
+				}
+			case "jpeg-quality":
+				if targetSize == 0 {
+					p.JpegQuality = *jpegQualityFlag
+				}
+			case "grayscale":
+				p.Grayscale = *grayscaleFlag
+			case "subset-fonts":
+				p.SubsetFonts = *subsetFontsFlag
+			}
+		})
+		return p
+	}
+
+	if *outputDirFlag != "" {
+		if err := os.MkdirAll(*outputDirFlag, 0755); err != nil {
+			fmt.Printf("Could not create output dir %v: %v\n", *outputDirFlag, err)
+			os.Exit(1)
+		}
+	}
+
+	if err := executil.HasExecutables("gs"); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if err := encryptOptions.Check(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	if *passwordFlag != "" && *inPlaceFlag && !encryptOptions.Enabled() {
+		// the unencrypted output would replace the protected original
+		fmt.Println("--password with --in-place removes the protection of the files. Encrypt the outputs with --user-password or --owner-password, or use --output-dir.")
 		os.Exit(1)
 	}
+	if *passwordFlag != "" || encryptOptions.Enabled() {
+		if err := executil.HasExecutables("qpdf"); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
+	if *pdfaFlag != "" {
+		if err := pdfutil.CheckPdfALevel(*pdfaFlag); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		if encryptOptions.Enabled() {
+			fmt.Println("PDF/A documents can not be encrypted.")
+			os.Exit(1)
+		}
+		if *iccProfileFlag == "" {
+			iccProfile, err := pdfutil.FindIccProfile()
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
+			}
+			*iccProfileFlag = iccProfile
+		}
+	}
+
+	opts := options{
+		Profile:    selectedProfile,
+		TargetSize: targetSize,
+		Override:   override,
+		InPlace:    *inPlaceFlag,
+		OutputDir:  *outputDirFlag,
+		MinSavings: *minSavingsFlag / 100,
+		Password:   *passwordFlag,
+		Encrypt:    *encryptOptions,
+		PdfA:       *pdfaFlag,
+		IccProfile: *iccProfileFlag,
+	}
+
+	done := make(chan struct{})
+	defer close(done)
+
+	// findPdfFiles will produce filenames that reduceWorker will consume
+	paths, errc := findPdfFiles(done, flag.Args(), opts.OutputDir)
+	results := make(chan result)
+
+	reduceWorkerWaitGroup.Add(reduceWorkerTotal)
+	for i := 0; i < reduceWorkerTotal; i++ {
+		go reduceWorker(done, paths, results, opts)
+	}
+	go func() {
+		reduceWorkerWaitGroup.Wait()
+		close(results)
+	}()
+
+	var allResults []result
+	encoder := json.NewEncoder(os.Stdout)
+	for r := range results {
+		if *jsonFlag {
+			if err := encoder.Encode(r.toJson(opts.PdfA)); err != nil {
+				fmt.Fprintln(humanOutput, err)
+			}
+		}
+		allResults = append(allResults, r)
+	}
+
+	if err := <-errc; err != nil {
+		fmt.Fprintln(humanOutput, err)
+	}
+
+	if !*jsonFlag {
+		printSummary(allResults, opts.MinSavings)
+	}
+}
+
+func reduceWorker(done <-chan struct{}, paths <-chan pdfFile, results chan<- result, opts options) {
+	defer reduceWorkerWaitGroup.Done()
+
+	for file := range paths {
+		r := reduceFile(file, opts)
+		if r.Err != nil {
+			fmt.Fprintln(humanOutput, r.Err)
+		}
+		select {
+		case results <- r:
+		case <-done:
+			return
+		}
+	}
+}
+
+// reduceFile reduces file according to opts. The output is removed if the
+// reduction is below opts.MinSavings.
+func reduceFile(file pdfFile, opts options) (r result) {
+	inputFile := file.Path
+	r.InputFile = inputFile
+	start := time.Now()
+	defer func() { r.Duration = time.Since(start) }()
+
+	inputFileinfo, err := os.Stat(inputFile)
+	if err != nil {
+		r.Err = fmt.Errorf("Could not stat input file %v: %v", inputFile, err)
+		return r
+	}
+	r.OriginalSize = inputFileinfo.Size()
+
+	r.OutputFile = outputFileFor(file, opts)
+	workingFile := r.OutputFile
+	if opts.InPlace {
+		workingFile = withoutExt(inputFile) + ".pdfreduce.pdf"
+	}
+	if opts.InPlace {
+		if _, err := os.Lstat(inputFile + backupCopyExtension); err == nil {
+			r.Err = fmt.Errorf("Not replacing '%v': its backup '%v' already exists", inputFile, inputFile+backupCopyExtension)
+			return r
+		}
+	}
+	if opts.OutputDir != "" {
+		if r.OutputFile == inputFile {
+			r.Err = fmt.Errorf("Not overwriting '%v' with its reduced copy, use --in-place", inputFile)
+			return r
+		}
+		if err := os.MkdirAll(filepath.Dir(r.OutputFile), 0755); err != nil {
+			r.Err = err
+			return r
+		}
+	}
 
-	if outputFileinfo.Size() > int64(float64(inputFileinfo.Size())*(1-MINIMUM_FILESIZE_REDUCTION_EXPECTED)) {
-		fmt.Println("Could not reduce the filesize significantly.")
-		os.Remove(outputFile)
-		return
+	// Work on a decrypted copy of encrypted inputs
+	sourceFile := inputFile
+	if opts.Password != "" {
+		tempDir, err := ioutil.TempDir("", "gopdfreduce")
+		if err != nil {
+			r.Err = err
+			return r
+		}
+		defer os.RemoveAll(tempDir)
+
+		sourceFile = filepath.Join(tempDir, filepath.Base(inputFile))
+		if err := pdfutil.Decrypt(inputFile, sourceFile, opts.Password); err != nil {
+			r.Err = err
+			return r
+		}
+	}
+
+	selectedProfile := opts.Profile
+	if opts.TargetSize > 0 {
+		if inputFileinfo.Size() <= opts.TargetSize {
+			fmt.Fprintf(humanOutput, "\t'%v' already fits the target size of %v.\n", inputFile, HumanReadableSizeBytes(opts.TargetSize))
+			r.Skipped = true
+			return r
+		}
+		selectedProfile, err = reducePdfToTargetSize(sourceFile, workingFile, opts.TargetSize, opts.Override)
 	} else {
-		humanReadableInputSize := HumanReadableSizeBytes(inputFileinfo.Size())
-		humanReadableOutputSize := HumanReadableSizeBytes(outputFileinfo.Size())
-		reductionPercentage := float64(outputFileinfo.Size()) / float64(inputFileinfo.Size())
+		selectedProfile = opts.Override(selectedProfile)
+		err = reducePdfSizeUsingGhostScript(sourceFile, workingFile, selectedProfile)
+	}
+	r.Profile = selectedProfile.Name
+	if err != nil {
+		os.Remove(workingFile)
+		r.Err = err
+		return r
+	}
+
+	// converted before the size checks, as PDF/A may make it bigger again
+	if opts.PdfA != "" {
+		if err := pdfutil.ConvertToPdfAInPlace(workingFile, opts.PdfA, opts.IccProfile, selectedProfile.Grayscale); err != nil {
+			os.Remove(workingFile)
+			r.Err = err
+			return r
+		}
+
+		problems, validated, err := pdfutil.VerifyPdfA(workingFile, opts.PdfA)
+		if err != nil {
+			os.Remove(workingFile)
+			r.Err = err
+			return r
+		}
+		if validated {
+			r.PdfAProblems = problems
+		} else {
+			r.PdfAWarnings = problems
+		}
+		for _, problem := range r.PdfAProblems {
+			fmt.Fprintf(humanOutput, "\t'%v' is not PDF/A-%v compliant: %v\n", inputFile, opts.PdfA, problem)
+		}
+		for _, warning := range r.PdfAWarnings {
+			fmt.Fprintf(humanOutput, "\t'%v' may not be PDF/A-%v compliant (basic checks, install veraPDF to validate it): %v\n", inputFile, opts.PdfA, warning)
+		}
+	}
+
+	outputFileinfo, err := os.Stat(workingFile)
+	if err != nil {
+		r.Err = fmt.Errorf("BUG: could not stat output file %v: %v", workingFile, err)
+		return r
+	}
+	r.NewSize = outputFileinfo.Size()
+	if opts.PdfA != "" && opts.TargetSize > 0 && r.NewSize > opts.TargetSize {
+		fmt.Fprintf(humanOutput, "\t'%v' no longer fits the target size of %v as PDF/A.\n", inputFile, HumanReadableSizeBytes(opts.TargetSize))
+	}
+
+	if outputFileinfo.Size() > int64(float64(inputFileinfo.Size())*(1-opts.MinSavings)) {
+		fmt.Fprintf(humanOutput, "\tCould not reduce the filesize of '%v' significantly.\n", inputFile)
+		os.Remove(workingFile)
+		r.Skipped = true
+		return r
+	}
+
+	if opts.Encrypt.Enabled() {
+		if err := pdfutil.EncryptInPlace(workingFile, opts.Encrypt); err != nil {
+			os.Remove(workingFile)
+			r.Err = err
+			return r
+		}
+		if outputFileinfo, err = os.Stat(workingFile); err != nil {
+			r.Err = err
+			return r
+		}
+		r.NewSize = outputFileinfo.Size()
+	}
+
+	if opts.InPlace {
+		if err := replaceWithBackup(inputFile, workingFile); err != nil {
+			os.Remove(workingFile)
+			r.Err = err
+			return r
+		}
+	}
+
+	humanReadableInputSize := HumanReadableSizeBytes(inputFileinfo.Size())
+	humanReadableOutputSize := HumanReadableSizeBytes(outputFileinfo.Size())
+
+	fmt.Fprintf(humanOutput, "\tReduced size of '%v' from %v to %v (-%.1f%%) using profile '%v'. '%v' created.\n", inputFile, humanReadableInputSize, humanReadableOutputSize, r.savingsPercentage(), selectedProfile.Name, r.OutputFile)
+	return r
+}
+
+// replaceWithBackup replaces inputFile with workingFile, keeping the original
+// as the backup copy. An existing backup is never replaced, as it may be the
+// only copy of the original.
+func replaceWithBackup(inputFile string, workingFile string) error {
+	backupFile := inputFile + backupCopyExtension
+	if err := os.Link(inputFile, backupFile); err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("Not replacing '%v': its backup '%v' already exists", inputFile, backupFile)
+		}
+
+		// e.g. on filesystems without hard links
+		if _, err := os.Lstat(backupFile); err == nil {
+			return fmt.Errorf("Not replacing '%v': its backup '%v' already exists", inputFile, backupFile)
+		}
+		if err := os.Rename(inputFile, backupFile); err != nil {
+			return err
+		}
+		if err := os.Rename(workingFile, inputFile); err != nil {
+			os.Rename(backupFile, inputFile)
+			return err
+		}
+		return nil
+	}
+
+	if err := os.Rename(workingFile, inputFile); err != nil {
+		os.Remove(backupFile)
+		return err
+	}
+	return nil
+}
+
+// outputFileFor returns where the reduced file is written. Files of walked
+// dirs keep their path relative to the walked dir inside opts.OutputDir.
+func outputFileFor(file pdfFile, opts options) string {
+	switch {
+	case opts.InPlace:
+		return file.Path
+	case opts.OutputDir != "":
+		outputFile, err := filepath.Abs(filepath.Join(opts.OutputDir, file.Rel))
+		if err != nil {
+			return filepath.Join(opts.OutputDir, file.Rel)
+		}
+		return outputFile
+	case opts.Profile.Name == "screen" || opts.TargetSize > 0:
+		return withoutExt(file.Path) + " - highly compressed.pdf"
+	default:
+		return withoutExt(file.Path) + " - compressed.pdf"
+	}
+}
+
+// withoutExt returns path without its extension, e.g. 'a/b' for 'a/b.pdf'.
+func withoutExt(path string) string {
+	return path[:len(path)-len(filepath.Ext(path))]
+}
+
+func printSummary(results []result, minSavings float64) {
+	sort.Slice(results, func(i, j int) bool { return results[i].InputFile < results[j].InputFile })
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintln(w, "File\tOriginal\tReduced\tSavings\t")
+
+	var skipped, failed int
+	var totalOriginal, totalNew int64
+	for _, r := range results {
+		switch {
+		case r.Err != nil:
+			failed++
+			continue
+		case r.Skipped:
+			skipped++
+			continue
+		}
+
+		totalOriginal += r.OriginalSize
+		totalNew += r.NewSize
+		fmt.Fprintf(w, "%v\t%v\t%v\t%.1f%%\t\n", r.InputFile, HumanReadableSizeBytes(r.OriginalSize), HumanReadableSizeBytes(r.NewSize), r.savingsPercentage())
+	}
+	if totalOriginal > 0 {
+		total := result{OriginalSize: totalOriginal, NewSize: totalNew}
+		fmt.Fprintf(w, "Total\t%v\t%v\t%.1f%%\t\n", HumanReadableSizeBytes(totalOriginal), HumanReadableSizeBytes(totalNew), total.savingsPercentage())
+	}
+	w.Flush()
 
-		fmt.Printf("\tReduced size of '%v' from %v to %v (%.2f%%). '%v' created.\n", inputFile, humanReadableInputSize, humanReadableOutputSize, reductionPercentage, outputFile)
+	if skipped > 0 {
+		fmt.Printf("%v files skipped (less than %.1f%% reduction)\n", skipped, 100*minSavings)
+	}
+	if failed > 0 {
+		fmt.Printf("%v files failed\n", failed)
 	}
 }
 
-func reducePdfSizeUsingGhostScript(inputFile string, outputFile string, maxFlag bool) {
+func reducePdfSizeUsingGhostScript(inputFile string, outputFile string, p profile) error {
 	// gs -sDEVICE=pdfwrite -dCompatibilityLevel=1.4 -dPDFSETTINGS=/ebook -dNOPAUSE -dQUIET -dBATCH -sOutputFile=output.pdf input.pdf
 	outputFileArg := fmt.Sprintf("-sOutputFile=%v", outputFile)
-	pdfSettings := "-dPDFSETTINGS=/ebook"
-	if maxFlag {
-		pdfSettings = "-dPDFSETTINGS=/screen"
-	}
-	args := []string{"-sDEVICE=pdfwrite", "-dCompatibilityLevel=1.4", pdfSettings, "-dNOPAUSE", "-dQUIET", "-dBATCH", outputFileArg, inputFile}
+	args := []string{"-sDEVICE=pdfwrite", "-dCompatibilityLevel=1.4"}
+	args = append(args, p.ghostScriptArgs()...)
+	args = append(args, "-dNOPAUSE", "-dQUIET", "-dBATCH", outputFileArg, inputFile)
 
 	gs := exec.Command("gs", args...)
-	executil.MustRun(gs)
+	_, err := executil.RunWithVerboseError(gs)
+	return err
 }
 
+// findPdfFiles produces the pdf files given in args. Directories are walked
+// and 'dir/...' walks dir recursively. When outputDir is set, it is not walked
+// and files that would be written to the same output are skipped.
+func findPdfFiles(done <-chan struct{}, args []string, outputDir string) (<-chan pdfFile, <-chan error) {
+	paths := make(chan pdfFile)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(paths)
+
+		if outputDir != "" {
+			outputDir, _ = filepath.Abs(outputDir)
+		}
+		targets := make(map[string]string) // input file by output path relative to outputDir
+		isNewTarget := func(file pdfFile) bool {
+			if outputDir == "" {
+				return true
+			}
+			if other, ok := targets[file.Rel]; ok && other != file.Path {
+				fmt.Fprintf(humanOutput, "Skipping '%v': '%v' is also written to '%v'\n", file.Path, other, filepath.Join(outputDir, file.Rel))
+				return false
+			}
+			targets[file.Rel] = file.Path
+			return true
+		}
+
+		for _, arg := range args {
+			root := arg
+			isRecursive := false
+			if strings.HasSuffix(arg, "/...") {
+				root = strings.TrimSuffix(arg, "/...")
+				isRecursive = true
+			}
+
+			info, err := os.Stat(root)
+			if err != nil {
+				fmt.Fprintln(humanOutput, err)
+				continue
+			}
+
+			if !info.IsDir() {
+				abs, _ := filepath.Abs(root)
+				file := pdfFile{Path: abs, Rel: filepath.Base(abs)}
+				if !isNewTarget(file) {
+					continue
+				}
+				select {
+				case paths <- file:
+				case <-done:
+					errc <- errors.New("walk canceled")
+					return
+				}
+				continue
+			}
+
+			dirPaths, dirErrc := walkFiles(done, root, isRecursive, outputDir)
+			for file := range dirPaths {
+				if !isNewTarget(file) {
+					continue
+				}
+				select {
+				case paths <- file:
+				case <-done:
+					errc <- errors.New("walk canceled")
+					return
+				}
+			}
+			if err := <-dirErrc; err != nil {
+				errc <- err
+				return
+			}
+		}
+		errc <- nil
+	}()
+	return paths, errc
+}
+
+// walkFiles produces the pdfs in root, and in its subdirs if isRecursive,
+// except the ones in excludeDir.
+func walkFiles(done <-chan struct{}, root string, isRecursive bool, excludeDir string) (<-chan pdfFile, <-chan error) {
+	paths := make(chan pdfFile)
+	errc := make(chan error, 1)
+
+	go func() {
+		defer close(paths)
+		absRoot, err := filepath.Abs(root)
+		if err != nil {
+			errc <- err
+			return
+		}
+		errc <- filepath.Walk(absRoot, func(path string, info os.FileInfo, err error) error {
+			if err != nil {
+				fmt.Fprintln(humanOutput, err)
+				return nil
+			}
+
+			if info.IsDir() {
+				if strings.HasPrefix(info.Name(), ".pdfreduce") {
+					// working dir of reducePdfToTargetSize
+					return filepath.SkipDir
+				}
+				if path == excludeDir {
+					// outputs of this run
+					return filepath.SkipDir
+				}
+				if isRecursive || path == absRoot {
+					fmt.Fprintf(humanOutput, "Walk in '%v'\n", path)
+					return nil
+				} else {
+					return filepath.SkipDir
+				}
+			}
+
+			if isPdfToReduce(path) {
+				rel, err := filepath.Rel(absRoot, path)
+				if err != nil {
+					return err
+				}
+				select {
+				case paths <- pdfFile{Path: path, Rel: rel}:
+				case <-done:
+					return errors.New("walk canceled")
+				}
+			}
+			return nil
+		})
+	}()
+	return paths, errc
+}
+
+// isPdfToReduce reports whether path is a pdf that was not created by pdfreduce.
+func isPdfToReduce(path string) bool {
+	if strings.ToLower(filepath.Ext(path)) != ".pdf" {
+		return false
+	}
+	for _, suffix := range []string{" - compressed.pdf", " - highly compressed.pdf", ".pdfreduce.pdf", ".encrypted.pdf", ".pdfa.pdf"} {
+		if strings.HasSuffix(path, suffix) {
+			return false
+		}
+	}
+	return true
+}
+
+// parseSize parses a size with the decimal units of HumanReadableSizeBytes,
+// e.g. 5M or 1.5GB.
+func parseSize(size string) (int64, error) {
+	units := []struct {
+		Suffix     string
+		Multiplier float64
+	}{
+		{"T", 1e12},
+		{"G", 1e9},
+		{"M", 1e6},
+		{"K", 1e3},
+		{"", 1},
+	}
+
+	number := strings.TrimSuffix(strings.ToUpper(strings.TrimSpace(size)), "B")
+	for _, unit := range units {
+		if !strings.HasSuffix(number, unit.Suffix) {
+			continue
+		}
+		value, err := strconv.ParseFloat(strings.TrimSpace(strings.TrimSuffix(number, unit.Suffix)), 64)
+		if err != nil || value < 0 || math.IsNaN(value) || value*unit.Multiplier >= math.MaxInt64 {
+			return 0, fmt.Errorf("invalid size '%v'", size)
+		}
+		return int64(value * unit.Multiplier), nil
+	}
+	return 0, fmt.Errorf("invalid size '%v'", size)
+}
+
+// HumanReadableSizeBytes formats size with decimal units, e.g. 1.2MB.
 func HumanReadableSizeBytes(size int64) string {
+	if size <= 0 {
+		return fmt.Sprintf("%v%v", size, "B")
+	}
 	sizeFloat := float64(size)
 
 	magnitude := math.Floor(math.Log10(sizeFloat))
 
 	switch {
 	case magnitude >= 12:
-		return fmt.Sprintf("%v%v", sizeFloat/math.Pow10(12), "TB")
+		return fmt.Sprintf("%.1f%v", sizeFloat/math.Pow10(12), "TB")
 	case magnitude >= 9:
-		return fmt.Sprintf("%v%v", sizeFloat/math.Pow10(9), "GB")
+		return fmt.Sprintf("%.1f%v", sizeFloat/math.Pow10(9), "GB")
 	case magnitude >= 6:
-		return fmt.Sprintf("%v%v", sizeFloat/math.Pow10(6), "MB")
+		return fmt.Sprintf("%.1f%v", sizeFloat/math.Pow10(6), "MB")
 	case magnitude >= 3:
-		return fmt.Sprintf("%v%v", sizeFloat/math.Pow10(3), "KB")
+		return fmt.Sprintf("%.1f%v", sizeFloat/math.Pow10(3), "KB")
 	default:
 		return fmt.Sprintf("%v%v", size, "B")
 	}
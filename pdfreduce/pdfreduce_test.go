@@ -0,0 +1,39 @@
+package main
+
+import "testing"
+
+func TestParseSize(t *testing.T) {
+	tests := []struct {
+		size  string
+		bytes int64
+		err   bool
+	}{
+		{"123", 123, false},
+		{"5M", 5000000, false},
+		{"5MB", 5000000, false},
+		{"5mb", 5000000, false},
+		{"1.5G", 1500000000, false},
+		{"500K", 500000, false},
+		{"2 KB", 2000, false},
+		{"1T", 1000000000000, false},
+		{"0", 0, false},
+		{"", 0, true},
+		{"B", 0, true},
+		{"M", 0, true},
+		{"5X", 0, true},
+		{"-5M", 0, true},
+		{"NaN", 0, true},
+		{"1e20T", 0, true},
+	}
+
+	for _, test := range tests {
+		bytes, err := parseSize(test.size)
+		if (err != nil) != test.err {
+			t.Errorf("parseSize(%q) error = %v, want error %v", test.size, err, test.err)
+			continue
+		}
+		if bytes != test.bytes {
+			t.Errorf("parseSize(%q) = %v, want %v", test.size, bytes, test.bytes)
+		}
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// profile holds the ghostscript settings used to reduce a pdf.
+type profile struct {
+	Name        string
+	PdfSettings string // ghostscript predefined settings: /screen, /ebook, /printer, /prepress or /default
+	ImageDpi    int    // color and gray images are downsampled to this resolution, 0 keeps the PdfSettings default
+	JpegQuality int    // quality (0-100) used to recompress color and gray images, 0 keeps the PdfSettings default
+	Grayscale   bool
+	SubsetFonts bool
+}
+
+var profiles = map[string]profile{
+	"screen":   {Name: "screen", PdfSettings: "/screen", ImageDpi: 72, SubsetFonts: true},
+	"ebook":    {Name: "ebook", PdfSettings: "/ebook", ImageDpi: 150, SubsetFonts: true},
+	"printer":  {Name: "printer", PdfSettings: "/printer", ImageDpi: 300, SubsetFonts: true},
+	"prepress": {Name: "prepress", PdfSettings: "/prepress", ImageDpi: 300, SubsetFonts: true},
+	"custom":   {Name: "custom", PdfSettings: "/default", SubsetFonts: true},
+}
+
+// targetSizeProfiles are tried in order, from the mildest to the strongest,
+// until the output fits the target size.
+var targetSizeProfiles = []profile{
+	{Name: "ebook", PdfSettings: "/ebook", ImageDpi: 150, SubsetFonts: true},
+	{Name: "target-120dpi", PdfSettings: "/ebook", ImageDpi: 120, JpegQuality: 75, SubsetFonts: true},
+	{Name: "target-96dpi", PdfSettings: "/screen", ImageDpi: 96, JpegQuality: 60, SubsetFonts: true},
+	{Name: "screen", PdfSettings: "/screen", ImageDpi: 72, JpegQuality: 50, SubsetFonts: true},
+	{Name: "target-50dpi", PdfSettings: "/screen", ImageDpi: 50, JpegQuality: 30, SubsetFonts: true},
+}
+
+func (p profile) ghostScriptArgs() []string {
+	args := []string{"-dPDFSETTINGS=" + p.PdfSettings}
+
+	if p.ImageDpi > 0 {
+		args = append(args,
+			"-dDownsampleColorImages=true",
+			"-dDownsampleGrayImages=true",
+			"-dColorImageDownsampleType=/Bicubic",
+			"-dGrayImageDownsampleType=/Bicubic",
+			fmt.Sprintf("-dColorImageResolution=%d", p.ImageDpi),
+			fmt.Sprintf("-dGrayImageResolution=%d", p.ImageDpi),
+		)
+	}
+
+	if p.JpegQuality > 0 {
+		args = append(args,
+			"-dAutoFilterColorImages=false",
+			"-dAutoFilterGrayImages=false",
+			"-sColorImageFilter=/DCTEncode",
+			"-sGrayImageFilter=/DCTEncode",
+			fmt.Sprintf("-dJPEGQ=%d", p.JpegQuality),
+		)
+	}
+
+	if p.Grayscale {
+		args = append(args, "-sColorConversionStrategy=Gray", "-dProcessColorModel=/DeviceGray")
+	}
+
+	if p.SubsetFonts {
+		args = append(args, "-dEmbedAllFonts=true", "-dSubsetFonts=true")
+	} else {
+		args = append(args, "-dSubsetFonts=false")
+	}
+
+	return args
+}
+
+// reducePdfToTargetSize tries targetSizeProfiles until the output is at most
+// targetSize bytes. If none fits, the smallest output is kept. It returns the
+// profile used to create outputFile.
+func reducePdfToTargetSize(inputFile string, outputFile string, targetSize int64, override func(profile) profile) (profile, error) {
+	tempDir, err := ioutil.TempDir(filepath.Dir(outputFile), ".pdfreduce")
+	if err != nil {
+		return profile{}, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	var best profile
+	var bestFile string
+	var bestSize int64
+	for i, p := range targetSizeProfiles {
+		p = override(p)
+
+		attemptFile := filepath.Join(tempDir, fmt.Sprintf("attempt%d.pdf", i))
+		err := reducePdfSizeUsingGhostScript(inputFile, attemptFile, p)
+		if err != nil {
+			return profile{}, err
+		}
+
+		fileinfo, err := os.Stat(attemptFile)
+		if err != nil {
+			return profile{}, err
+		}
+
+		if bestFile == "" || fileinfo.Size() < bestSize {
+			best, bestFile, bestSize = p, attemptFile, fileinfo.Size()
+		}
+
+		if fileinfo.Size() <= targetSize {
+			best, bestFile, bestSize = p, attemptFile, fileinfo.Size()
+			break
+		}
+		fmt.Fprintf(humanOutput, "\tProfile '%v' produced %v, above target of %v\n", p.Name, HumanReadableSizeBytes(fileinfo.Size()), HumanReadableSizeBytes(targetSize))
+	}
+
+	if bestSize > targetSize {
+		fmt.Fprintf(humanOutput, "\tCould not reach target of %v, keeping smallest result (%v)\n", HumanReadableSizeBytes(targetSize), HumanReadableSizeBytes(bestSize))
+	}
+
+	return best, os.Rename(bestFile, outputFile)
+}
@@ -1,14 +1,16 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io/ioutil"
 	"log"
 	"math"
 	"os"
-	"os/exec"
-	"strconv"
+	"path/filepath"
 
 	"github.com/mateusbraga/tools/executil"
+	"github.com/mateusbraga/tools/pdfutil"
 )
 
 const (
@@ -16,12 +18,42 @@ const (
 )
 
 func main() {
-	if len(os.Args) != 2 {
-		log.Fatalf("Usage: splitpdf file.pdf\n")
+	passwordFlag, encryptOptions := pdfutil.PasswordFlags()
+	flag.Parse()
+
+	if len(flag.Args()) != 1 {
+		log.Fatalf("Usage: splitpdf [--password secret] file.pdf\n")
+	}
+	inputFile := flag.Arg(0)
+
+	if err := encryptOptions.Check(); err != nil {
+		log.Fatalln(err)
 	}
-	inputFile := os.Args[1]
 
-	numberOfPages := getNumberOfPagesUsingGhostScript(inputFile)
+	// Work on a decrypted copy of encrypted inputs
+	sourceFile := inputFile
+	if *passwordFlag != "" || encryptOptions.Enabled() {
+		if err := executil.HasExecutables("qpdf"); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	if *passwordFlag != "" {
+		tempDir, err := ioutil.TempDir("", "gosplitpdf")
+		if err != nil {
+			log.Fatalln(err)
+		}
+		defer os.RemoveAll(tempDir)
+
+		sourceFile = filepath.Join(tempDir, "decrypted.pdf")
+		if err := pdfutil.Decrypt(inputFile, sourceFile, *passwordFlag); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	numberOfPages, err := pdfutil.NumberOfPages(sourceFile)
+	if err != nil {
+		log.Fatalln("Failed to get number of pages:", err)
+	}
 
 	numberOfOutputFiles := int(math.Ceil(float64(numberOfPages) / float64(MAX_NUMBER_OF_PAGES)))
 
@@ -29,34 +61,16 @@ func main() {
 	for i := 0; i < numberOfOutputFiles; i++ {
 		log.Printf("\tFrom %v to %v\n", i*MAX_NUMBER_OF_PAGES+1, (i+1)*MAX_NUMBER_OF_PAGES)
 		outputFile := fmt.Sprintf("%d_%v", i+1, inputFile)
-		splitUsingGhostScript(inputFile, i*MAX_NUMBER_OF_PAGES+1, (i+1)*MAX_NUMBER_OF_PAGES, outputFile)
-	}
-	log.Printf("Done\n")
-}
-
-func splitUsingGhostScript(inputFile string, initialPage, lastPage int, outputFile string) {
-	//gs -sDEVICE=pdfwrite -dNOPAUSE -dBATCH -dSAFER -dFirstPage=1 -dLastPage=4 -sOutputFile=outputT4.pdf T4.pdf
-	initialPageArg := fmt.Sprintf("-dFirstPage=%d", initialPage)
-	lastPageArg := fmt.Sprintf("-dLastPage=%d", lastPage)
-	outputFileArg := fmt.Sprintf("-sOutputFile=%v", outputFile)
-	args := []string{"-sDEVICE=pdfwrite", "-dNOPAUSE", "-dBATCH", "-dSAFER", initialPageArg, lastPageArg, outputFileArg, inputFile}
-
-	gs := exec.Command("gs", args...)
-	executil.MustRun(gs)
-}
+		err := pdfutil.ExtractPages(sourceFile, i*MAX_NUMBER_OF_PAGES+1, (i+1)*MAX_NUMBER_OF_PAGES, outputFile)
+		if err != nil {
+			log.Fatalln(err)
+		}
 
-func getNumberOfPagesUsingGhostScript(pdfFile string) int {
-	//gs -q -dNODISPLAY -c "(Code Complete - Steve McConnel.pdf) (r) file runpdfbegin pdfpagecount = quit"
-	cmdArg := fmt.Sprintf("(%v) (r) file runpdfbegin pdfpagecount = quit", pdfFile)
-	args := []string{"-q", "-dNODISPLAY", "-c", cmdArg}
-
-	gs := exec.Command("gs", args...)
-	output := executil.MustRun(gs)
-
-	// remove new line
-	numberOfPages, err := strconv.ParseInt(output[:len(output)-1], 10, 0)
-	if err != nil {
-		log.Fatalln("Failed to get number of pages:", err)
+		if encryptOptions.Enabled() {
+			if err := pdfutil.EncryptInPlace(outputFile, *encryptOptions); err != nil {
+				log.Fatalln(err)
+			}
+		}
 	}
-	return int(numberOfPages)
+	log.Printf("Done\n")
 }
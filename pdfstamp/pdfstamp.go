@@ -0,0 +1,84 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/mateusbraga/tools/executil"
+	"github.com/mateusbraga/tools/pdfutil"
+)
+
+func main() {
+	outputDirFlag := flag.String("output-dir", "", "Write the stamped files to this directory (default: 'file - stamped.pdf' next to each file)")
+	stampOptions := pdfutil.StampFlags()
+	flag.Parse()
+
+	if len(flag.Args()) == 0 || !stampOptions.Enabled() {
+		fmt.Println("Usage: pdfstamp --stamp-text CONFIDENTIAL|--stamp-image logo.png|--bates-prefix ACME [flags] file.pdf ...")
+		os.Exit(1)
+	}
+	if err := stampOptions.Check(); err != nil {
+		log.Fatalln(err)
+	}
+
+	err := executil.HasExecutables("gs")
+	if err == nil && stampOptions.Image != "" {
+		err = executil.HasExecutables("convert")
+	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	if *outputDirFlag != "" {
+		if err := os.MkdirAll(*outputDirFlag, 0755); err != nil {
+			log.Fatalln(err)
+		}
+	}
+
+	// Outputs are checked before stamping anything, so bates numbers are not
+	// used by a file that can not be written
+	outputFiles := make(map[string]string) // input file by output file
+	for _, inputFile := range flag.Args() {
+		outputFile, err := filepath.Abs(outputFileFor(inputFile, *outputDirFlag))
+		if err != nil {
+			log.Fatalln(err)
+		}
+		if other, ok := outputFiles[outputFile]; ok {
+			log.Fatalf("'%v' and '%v' would both be stamped to '%v'\n", other, inputFile, outputFile)
+		}
+		if abs, err := filepath.Abs(inputFile); err == nil && abs == outputFile {
+			log.Fatalf("Not overwriting '%v' with its stamped copy\n", inputFile)
+		}
+		outputFiles[outputFile] = inputFile
+	}
+
+	// Files are stamped in order, so bates numbers continue from one file to the next
+	for _, inputFile := range flag.Args() {
+		outputFile := outputFileFor(inputFile, *outputDirFlag)
+
+		numberOfPages, err := pdfutil.Stamp(inputFile, outputFile, *stampOptions)
+		if err != nil {
+			log.Fatalln(err)
+		}
+
+		if stampOptions.BatesPrefix != "" {
+			log.Printf("Stamped '%v' -> '%v' (bates %v%0*d to %v%0*d)", inputFile, outputFile,
+				stampOptions.BatesPrefix, stampOptions.BatesDigits, stampOptions.BatesStart,
+				stampOptions.BatesPrefix, stampOptions.BatesDigits, stampOptions.BatesStart+numberOfPages-1)
+		} else {
+			log.Printf("Stamped '%v' -> '%v'", inputFile, outputFile)
+		}
+		stampOptions.BatesStart += numberOfPages
+	}
+}
+
+// outputFileFor returns where the stamped copy of inputFile is written.
+func outputFileFor(inputFile string, outputDir string) string {
+	if outputDir != "" {
+		return filepath.Join(outputDir, filepath.Base(inputFile))
+	}
+	return inputFile[:len(inputFile)-len(filepath.Ext(inputFile))] + " - stamped.pdf"
+}
@@ -0,0 +1,195 @@
+package pdfutil
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mateusbraga/tools/executil"
+)
+
+const (
+	// Resolution used to render pages when looking for blank ones
+	DEFAULT_BLANK_DPI = 30
+	// Pages with at most this percentage of ink are considered blank
+	DEFAULT_BLANK_THRESHOLD = 0.5
+
+	// Gray level (0-255) under which a pixel counts as ink. Scanned paper is
+	// rarely pure white, so anything lighter is treated as background.
+	inkGrayLevel = 160
+)
+
+// InkCoverage renders every page of pdfFile in grayscale at dpi and returns
+// the percentage of each page covered by ink, in page order.
+func InkCoverage(pdfFile string, dpi int) ([]float64, error) {
+	tempDir, err := ioutil.TempDir("", "goinkcoverage")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	// gs -sDEVICE=pgmraw -r30 -dNOPAUSE -dBATCH -dSAFER -sOutputFile=page%06d.pgm input.pdf
+	resolutionArg := fmt.Sprintf("-r%d", dpi)
+	outputFileArg := fmt.Sprintf("-sOutputFile=%v", filepath.Join(tempDir, "page%06d.pgm"))
+	args := []string{"-sDEVICE=pgmraw", resolutionArg, "-dNOPAUSE", "-dBATCH", "-dSAFER", "-dQUIET", outputFileArg, pdfFile}
+
+	gs := exec.Command("gs", args...)
+	if _, err := executil.RunWithVerboseError(gs); err != nil {
+		return nil, err
+	}
+
+	pages, err := filepath.Glob(filepath.Join(tempDir, "page*.pgm"))
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(pages)
+
+	var coverage []float64
+	for _, page := range pages {
+		c, err := pgmInkCoverage(page)
+		if err != nil {
+			return nil, fmt.Errorf("failed to measure ink coverage of '%v': %v", pdfFile, err)
+		}
+		coverage = append(coverage, c)
+	}
+	return coverage, nil
+}
+
+// BlankPages returns the pages (starting at 1) of pdfFile whose ink coverage
+// is at most threshold percent, along with the coverage of every page.
+func BlankPages(pdfFile string, dpi int, threshold float64) ([]int, []float64, error) {
+	coverage, err := InkCoverage(pdfFile, dpi)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var blankPages []int
+	for i, c := range coverage {
+		if c <= threshold {
+			blankPages = append(blankPages, i+1)
+		}
+	}
+	return blankPages, coverage, nil
+}
+
+// KeepPages writes only the given pages (starting at 1) of inputFile to outputFile.
+func KeepPages(inputFile string, pages []int, outputFile string) error {
+	if len(pages) == 0 {
+		return fmt.Errorf("no pages of '%v' to write to '%v'", inputFile, outputFile)
+	}
+
+	var pageList []string
+	for _, page := range pages {
+		pageList = append(pageList, strconv.Itoa(page))
+	}
+
+	// gs -sDEVICE=pdfwrite -dNOPAUSE -dBATCH -dSAFER -sPageList=1,3,4 -sOutputFile=output.pdf input.pdf
+	pageListArg := fmt.Sprintf("-sPageList=%v", strings.Join(pageList, ","))
+	outputFileArg := fmt.Sprintf("-sOutputFile=%v", outputFile)
+	args := []string{"-sDEVICE=pdfwrite", "-dNOPAUSE", "-dBATCH", "-dSAFER", pageListArg, outputFileArg, inputFile}
+
+	gs := exec.Command("gs", args...)
+	_, err := executil.RunWithVerboseError(gs)
+	return err
+}
+
+// RemovePages writes inputFile to outputFile without the given pages (starting at 1).
+func RemovePages(inputFile string, numberOfPages int, pages []int, outputFile string) error {
+	remove := make(map[int]bool)
+	for _, page := range pages {
+		remove[page] = true
+	}
+
+	var keep []int
+	for i := 1; i <= numberOfPages; i++ {
+		if !remove[i] {
+			keep = append(keep, i)
+		}
+	}
+	return KeepPages(inputFile, keep, outputFile)
+}
+
+// pgmInkCoverage returns the percentage of pixels darker than inkGrayLevel in
+// the binary (P5) pgm file.
+func pgmInkCoverage(pgmFile string) (float64, error) {
+	f, err := os.Open(pgmFile)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	r := bufio.NewReader(f)
+
+	// Header: magic number, width, height and maxval separated by whitespace
+	var header []int
+	magic, err := readPgmToken(r)
+	if err != nil {
+		return 0, err
+	}
+	if magic != "P5" {
+		return 0, fmt.Errorf("unexpected pgm magic number '%v'", magic)
+	}
+	for len(header) < 3 {
+		token, err := readPgmToken(r)
+		if err != nil {
+			return 0, err
+		}
+		value, err := strconv.Atoi(token)
+		if err != nil {
+			return 0, fmt.Errorf("invalid pgm header: %v", err)
+		}
+		header = append(header, value)
+	}
+	width, height, maxval := header[0], header[1], header[2]
+	if maxval > 255 {
+		return 0, fmt.Errorf("unsupported pgm maxval %v", maxval)
+	}
+
+	pixels := make([]byte, width*height)
+	if _, err := io.ReadFull(r, pixels); err != nil {
+		return 0, err
+	}
+	if len(pixels) == 0 {
+		return 0, nil
+	}
+
+	level := byte(inkGrayLevel * maxval / 255)
+	var ink int
+	for _, p := range pixels {
+		if p < level {
+			ink++
+		}
+	}
+	return 100 * float64(ink) / float64(len(pixels)), nil
+}
+
+// readPgmToken reads the next whitespace separated token of a pgm header,
+// skipping comments. It consumes the single whitespace following the token.
+func readPgmToken(r *bufio.Reader) (string, error) {
+	var token []byte
+	for {
+		b, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		switch {
+		case b == '#' && len(token) == 0:
+			if _, err := r.ReadString('\n'); err != nil {
+				return "", err
+			}
+		case b == ' ' || b == '\t' || b == '\n' || b == '\r':
+			if len(token) > 0 {
+				return string(token), nil
+			}
+		default:
+			token = append(token, b)
+		}
+	}
+}
@@ -0,0 +1,147 @@
+package pdfutil
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+
+	"github.com/mateusbraga/tools/executil"
+)
+
+// qpdf exits with this status when it succeeds with warnings
+const qpdfExitWarning = 3
+
+// EncryptOptions holds the passwords and permissions of an encrypted pdf.
+type EncryptOptions struct {
+	OwnerPassword string
+	UserPassword  string
+	NoPrint       bool
+	NoCopy        bool
+}
+
+// Enabled reports whether the output should be encrypted.
+func (o EncryptOptions) Enabled() bool {
+	return o.OwnerPassword != "" || o.UserPassword != "" || o.NoPrint || o.NoCopy
+}
+
+// Check returns an error if the options can not protect the output.
+func (o EncryptOptions) Check() error {
+	if (o.NoPrint || o.NoCopy) && o.OwnerPassword == "" {
+		return errors.New("an owner password is required to restrict permissions")
+	}
+	return nil
+}
+
+// PasswordFlags registers on the default flag set the flags to open
+// encrypted inputs and to encrypt outputs.
+func PasswordFlags() (*string, *EncryptOptions) {
+	password := flag.String("password", "", "Password to open encrypted input files")
+
+	opts := &EncryptOptions{}
+	flag.StringVar(&opts.OwnerPassword, "owner-password", "", "Encrypt the output (AES-256) with this owner password")
+	flag.StringVar(&opts.UserPassword, "user-password", "", "Encrypt the output (AES-256) with this password required to open it")
+	flag.BoolVar(&opts.NoPrint, "no-print", false, "Forbid printing the encrypted output")
+	flag.BoolVar(&opts.NoCopy, "no-copy", false, "Forbid copying text and images from the encrypted output")
+
+	return password, opts
+}
+
+// Decrypt writes an unencrypted copy of inputFile to outputFile.
+func Decrypt(inputFile string, outputFile string, password string) error {
+	passwordFile, err := secretFile(password)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(passwordFile)
+
+	// qpdf --password-file=password.txt --decrypt input.pdf output.pdf
+	args := []string{"--password-file=" + passwordFile, "--decrypt", inputFile, outputFile}
+
+	return runQpdf([]string{password}, args...)
+}
+
+// Encrypt writes a copy of inputFile protected with AES-256 to outputFile.
+func Encrypt(inputFile string, outputFile string, opts EncryptOptions) error {
+	if err := opts.Check(); err != nil {
+		return err
+	}
+
+	ownerPassword := opts.OwnerPassword
+	if ownerPassword == "" {
+		ownerPassword = opts.UserPassword
+	}
+
+	// the passwords are read by qpdf from an argument file
+	argFile, err := secretFile("--encrypt", opts.UserPassword, ownerPassword, "256")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(argFile)
+
+	// qpdf @args.txt --print=none --extract=n -- input.pdf output.pdf
+	args := []string{"@" + argFile}
+	if opts.NoPrint {
+		args = append(args, "--print=none")
+	}
+	if opts.NoCopy {
+		args = append(args, "--extract=n")
+	}
+	args = append(args, "--", inputFile, outputFile)
+
+	return runQpdf([]string{opts.UserPassword, ownerPassword}, args...)
+}
+
+// EncryptInPlace replaces pdfFile with a copy protected with AES-256.
+func EncryptInPlace(pdfFile string, opts EncryptOptions) error {
+	tempFile := pdfFile + ".encrypted.pdf"
+	if err := Encrypt(pdfFile, tempFile, opts); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return os.Rename(tempFile, pdfFile)
+}
+
+// secretFile writes lines to a temporary file only the user can read, so
+// passwords are not given to qpdf in its arguments, which any user can see.
+func secretFile(lines ...string) (string, error) {
+	for _, line := range lines {
+		if strings.ContainsAny(line, "\r\n") {
+			return "", errors.New("passwords can not have line breaks")
+		}
+	}
+
+	f, err := ioutil.TempFile("", "qpdf*.txt")
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.WriteString(strings.Join(lines, "\n") + "\n"); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// runQpdf runs qpdf with args. secrets are redacted from its error.
+func runQpdf(secrets []string, args ...string) error {
+	qpdf := exec.Command("qpdf", args...)
+	_, err := executil.RunWithVerboseError(qpdf)
+	if err == nil || (qpdf.ProcessState != nil && qpdf.ProcessState.ExitCode() == qpdfExitWarning) {
+		return nil
+	}
+
+	message := err.Error()
+	for _, secret := range secrets {
+		if secret != "" {
+			message = strings.Replace(message, secret, "<password>", -1)
+		}
+	}
+	return errors.New(message)
+}
@@ -0,0 +1,78 @@
+package pdfutil
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// PageRange is an inclusive range of pages, starting at 1.
+type PageRange struct {
+	First int
+	Last  int
+}
+
+// ParsePages parses a page selection like "1-3,7,10-" of a document with
+// numberOfPages pages and returns the selected pages in ascending order. An
+// empty selection selects every page.
+func ParsePages(selection string, numberOfPages int) ([]int, error) {
+	selected := make([]bool, numberOfPages+1)
+
+	if strings.TrimSpace(selection) == "" {
+		selection = "1-"
+	}
+
+	for _, part := range strings.Split(selection, ",") {
+		part = strings.TrimSpace(part)
+
+		first, last := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			first, last = part[:i], part[i+1:]
+			if first == "" {
+				first = "1"
+			}
+			if last == "" {
+				last = strconv.Itoa(numberOfPages)
+			}
+		}
+
+		firstPage, err := strconv.Atoi(first)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page selection '%v': %v", part, err)
+		}
+		lastPage, err := strconv.Atoi(last)
+		if err != nil {
+			return nil, fmt.Errorf("invalid page selection '%v': %v", part, err)
+		}
+		if firstPage < 1 || lastPage > numberOfPages || firstPage > lastPage {
+			return nil, fmt.Errorf("invalid page selection '%v': document has %v pages", part, numberOfPages)
+		}
+
+		for page := firstPage; page <= lastPage; page++ {
+			selected[page] = true
+		}
+	}
+
+	var pages []int
+	for page := 1; page <= numberOfPages; page++ {
+		if selected[page] {
+			pages = append(pages, page)
+		}
+	}
+	return pages, nil
+}
+
+// PageRanges groups ascending pages in ranges of consecutive pages, each with
+// at most maxLength pages.
+func PageRanges(pages []int, maxLength int) []PageRange {
+	var ranges []PageRange
+	for _, page := range pages {
+		n := len(ranges)
+		if n > 0 && ranges[n-1].Last == page-1 && ranges[n-1].Last-ranges[n-1].First+1 < maxLength {
+			ranges[n-1].Last = page
+			continue
+		}
+		ranges = append(ranges, PageRange{First: page, Last: page})
+	}
+	return ranges
+}
@@ -0,0 +1,64 @@
+package pdfutil
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParsePages(t *testing.T) {
+	tests := []struct {
+		selection     string
+		numberOfPages int
+		pages         []int
+		err           bool
+	}{
+		{"", 3, []int{1, 2, 3}, false},
+		{"  ", 2, []int{1, 2}, false},
+		{"2", 3, []int{2}, false},
+		{"1-3,7,10-", 11, []int{1, 2, 3, 7, 10, 11}, false},
+		{"-2", 5, []int{1, 2}, false},
+		{"4-", 5, []int{4, 5}, false},
+		{"3, 1-2", 5, []int{1, 2, 3}, false},
+		{"2-3,3-4", 5, []int{2, 3, 4}, false},
+		{"5-5", 5, []int{5}, false},
+		{"0", 5, nil, true},
+		{"6", 5, nil, true},
+		{"3-2", 5, nil, true},
+		{"1-6", 5, nil, true},
+		{"a", 5, nil, true},
+		{"1-b", 5, nil, true},
+		{"1,,2", 5, nil, true},
+		{"-", 0, nil, true},
+	}
+
+	for _, test := range tests {
+		pages, err := ParsePages(test.selection, test.numberOfPages)
+		if (err != nil) != test.err {
+			t.Errorf("ParsePages(%q, %v) error = %v, want error %v", test.selection, test.numberOfPages, err, test.err)
+			continue
+		}
+		if !reflect.DeepEqual(pages, test.pages) {
+			t.Errorf("ParsePages(%q, %v) = %v, want %v", test.selection, test.numberOfPages, pages, test.pages)
+		}
+	}
+}
+
+func TestPageRanges(t *testing.T) {
+	tests := []struct {
+		pages     []int
+		maxLength int
+		ranges    []PageRange
+	}{
+		{nil, 10, nil},
+		{[]int{1, 2, 3}, 10, []PageRange{{1, 3}}},
+		{[]int{1, 2, 4, 5, 7}, 10, []PageRange{{1, 2}, {4, 5}, {7, 7}}},
+		{[]int{1, 2, 3, 4, 5}, 2, []PageRange{{1, 2}, {3, 4}, {5, 5}}},
+	}
+
+	for _, test := range tests {
+		ranges := PageRanges(test.pages, test.maxLength)
+		if !reflect.DeepEqual(ranges, test.ranges) {
+			t.Errorf("PageRanges(%v, %v) = %v, want %v", test.pages, test.maxLength, ranges, test.ranges)
+		}
+	}
+}
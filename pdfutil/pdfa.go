@@ -0,0 +1,233 @@
+package pdfutil
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/mateusbraga/tools/executil"
+)
+
+// pdfaLevels maps the supported PDF/A conformance levels to the ghostscript PDFA value.
+var pdfaLevels = map[string]int{
+	"1b": 1,
+	"2b": 2,
+}
+
+// iccProfileLocations are searched, in order, for an sRGB profile to use as output intent.
+var iccProfileLocations = []string{
+	"/usr/share/ghostscript/iccprofiles/srgb.icc",
+	"/usr/share/ghostscript/*/iccprofiles/srgb.icc",
+	"/usr/local/share/ghostscript/*/iccprofiles/srgb.icc",
+	"/opt/homebrew/share/ghostscript/*/iccprofiles/srgb.icc",
+	"/usr/share/color/icc/ghostscript/srgb.icc",
+	"/usr/share/color/icc/sRGB.icc",
+}
+
+// pdfaDefinition is the ghostscript PDFA_def.ps that adds the output intent
+// and the document title required by PDF/A.
+var pdfaDefinition = `%%!
+/ICCProfile (%v) def
+[ /Title (%v) /DOCINFO pdfmark
+[/_objdef {icc_PDFA} /type /stream /OBJ pdfmark
+[{icc_PDFA} << /N 3 >> /PUT pdfmark
+[{icc_PDFA} ICCProfile (r) file /PUT pdfmark
+[/_objdef {OutputIntent_PDFA} /type /dict /OBJ pdfmark
+[{OutputIntent_PDFA} <<
+  /Type /OutputIntent
+  /S /GTS_PDFA1
+  /DestOutputProfile {icc_PDFA}
+  /OutputConditionIdentifier (sRGB)
+>> /PUT pdfmark
+[{Catalog} << /OutputIntents [ {OutputIntent_PDFA} ] >> /PUT pdfmark
+`
+
+// CheckPdfALevel returns an error if level is not a supported PDF/A conformance level.
+func CheckPdfALevel(level string) error {
+	if _, ok := pdfaLevels[level]; !ok {
+		return fmt.Errorf("unsupported PDF/A level '%v', use 1b or 2b", level)
+	}
+	return nil
+}
+
+// FindIccProfile returns the first sRGB icc profile found in the usual ghostscript locations.
+func FindIccProfile() (string, error) {
+	for _, pattern := range iccProfileLocations {
+		matches, _ := filepath.Glob(pattern)
+		if len(matches) > 0 {
+			return matches[len(matches)-1], nil
+		}
+	}
+	return "", errors.New("could not find an sRGB icc profile, use --icc-profile")
+}
+
+// ConvertToPdfA writes inputFile to outputFile as PDF/A of the given level
+// ("1b" or "2b"), embedding all fonts and using iccProfile as output intent.
+// Colors are converted to RGB, or to gray if grayscale.
+func ConvertToPdfA(inputFile string, outputFile string, level string, iccProfile string, grayscale bool) error {
+	if err := CheckPdfALevel(level); err != nil {
+		return err
+	}
+
+	tempDir, err := ioutil.TempDir("", "gopdfa")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	title := filepath.Base(outputFile)
+	title = title[:len(title)-len(filepath.Ext(title))]
+
+	definitionFile := filepath.Join(tempDir, "PDFA_def.ps")
+	definition := fmt.Sprintf(pdfaDefinition, postScriptEscape(iccProfile), postScriptEscape(title))
+	if err := ioutil.WriteFile(definitionFile, []byte(definition), 0600); err != nil {
+		return err
+	}
+
+	compatibilityLevel := "1.4"
+	if level == "2b" {
+		compatibilityLevel = "1.7"
+	}
+
+	colorModel := "RGB"
+	if grayscale {
+		// gray is allowed with the sRGB output intent
+		colorModel = "Gray"
+	}
+
+	// gs -dPDFA=2 -dPDFACompatibilityPolicy=1 -sColorConversionStrategy=RGB -sDEVICE=pdfwrite -sOutputFile=output.pdf PDFA_def.ps input.pdf
+	args := []string{
+		fmt.Sprintf("-dPDFA=%d", pdfaLevels[level]),
+		"-dPDFACompatibilityPolicy=1",
+		"-sColorConversionStrategy=" + colorModel,
+		"-dProcessColorModel=/Device" + colorModel,
+		"-sDEVICE=pdfwrite",
+		"-dCompatibilityLevel=" + compatibilityLevel,
+		"-dEmbedAllFonts=true",
+		"-dSubsetFonts=true",
+		"-dNOPAUSE", "-dBATCH", "-dQUIET",
+		"--permit-file-read=" + iccProfile,
+		fmt.Sprintf("-sOutputFile=%v", outputFile),
+		definitionFile,
+		inputFile,
+	}
+
+	gs := exec.Command("gs", args...)
+	_, err = executil.RunWithVerboseError(gs)
+	return err
+}
+
+// ConvertToPdfAInPlace replaces pdfFile with its PDF/A version.
+func ConvertToPdfAInPlace(pdfFile string, level string, iccProfile string, grayscale bool) error {
+	tempFile := pdfFile + ".pdfa.pdf"
+	if err := ConvertToPdfA(pdfFile, tempFile, level, iccProfile, grayscale); err != nil {
+		os.Remove(tempFile)
+		return err
+	}
+	return os.Rename(tempFile, pdfFile)
+}
+
+// VerifyPdfA returns the reasons why pdfFile is not a valid PDF/A of the
+// given level. validated is true when veraPDF is installed and validated it.
+// Otherwise the reasons come from a heuristic search of the raw file, which
+// can not see inside compressed object streams: they are only hints.
+func VerifyPdfA(pdfFile string, level string) (problems []string, validated bool, err error) {
+	if err := CheckPdfALevel(level); err != nil {
+		return nil, false, err
+	}
+
+	if executil.HasExecutables("verapdf") == nil {
+		problems, err = verifyPdfAUsingVeraPdf(pdfFile, level)
+		return problems, true, err
+	}
+	problems, err = verifyPdfABasic(pdfFile, level)
+	return problems, false, err
+}
+
+func verifyPdfAUsingVeraPdf(pdfFile string, level string) ([]string, error) {
+	// verapdf --flavour 2b --format text --verbose file.pdf
+	args := []string{"--flavour", level, "--format", "text", "--verbose", pdfFile}
+
+	// veraPDF exits with an error status for non compliant documents, the
+	// report is in the output either way.
+	verapdf := exec.Command("verapdf", args...)
+	output, err := verapdf.Output()
+	if len(output) == 0 && err != nil {
+		return nil, fmt.Errorf("verapdf failed on '%v': %v", pdfFile, err)
+	}
+
+	var problems []string
+	for _, line := range strings.Split(string(output), "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "PASS "):
+			return nil, nil
+		case line == "", strings.HasPrefix(line, "FAIL ") && strings.HasSuffix(line, filepath.Base(pdfFile)):
+			continue
+		default:
+			problems = append(problems, line)
+		}
+	}
+	if len(problems) == 0 {
+		problems = append(problems, "veraPDF reported the document as not compliant")
+	}
+	return problems, nil
+}
+
+var (
+	pdfaidPartRegexp     = regexp.MustCompile(`pdfaid:part(?:>|=["'])(\d)`)
+	fontDescriptorRegexp = regexp.MustCompile(`/Type\s*/FontDescriptor`)
+)
+
+func verifyPdfABasic(pdfFile string, level string) ([]string, error) {
+	content, err := ioutil.ReadFile(pdfFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var problems []string
+
+	match := pdfaidPartRegexp.FindSubmatch(content)
+	switch {
+	case match == nil:
+		problems = append(problems, "XMP metadata has no PDF/A identification (pdfaid:part)")
+	case string(match[1]) != level[:1]:
+		problems = append(problems, fmt.Sprintf("XMP metadata identifies PDF/A-%v instead of PDF/A-%v", string(match[1]), level[:1]))
+	}
+	// the catalog and the font descriptors may be in compressed object
+	// streams, where they can not be found
+	hasObjectStreams := bytes.Contains(content, []byte("/ObjStm"))
+
+	if !hasObjectStreams && !bytes.Contains(content, []byte("/OutputIntents")) {
+		problems = append(problems, "document has no output intent")
+	}
+	if bytes.Contains(content, []byte("/Encrypt")) {
+		problems = append(problems, "document is encrypted")
+	}
+	if bytes.Contains(content, []byte("/JavaScript")) {
+		problems = append(problems, "document contains JavaScript")
+	}
+	if level == "1b" && bytes.Contains(content, []byte("/S /Transparency")) {
+		problems = append(problems, "transparency is not allowed in PDF/A-1")
+	}
+
+	fontDescriptors := len(fontDescriptorRegexp.FindAllIndex(content, -1))
+	embeddedFonts := bytes.Count(content, []byte("/FontFile"))
+	if !hasObjectStreams && embeddedFonts < fontDescriptors {
+		problems = append(problems, fmt.Sprintf("only %v of %v fonts seem to be embedded", embeddedFonts, fontDescriptors))
+	}
+
+	return problems, nil
+}
+
+// postScriptEscape escapes s to be used inside a PostScript string.
+func postScriptEscape(s string) string {
+	r := strings.NewReplacer(`\`, `\\`, `(`, `\(`, `)`, `\)`)
+	return r.Replace(s)
+}
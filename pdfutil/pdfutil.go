@@ -0,0 +1,162 @@
+package pdfutil
+
+import (
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/mateusbraga/tools/executil"
+)
+
+// NumberOfPages returns the number of pages of pdfFile.
+func NumberOfPages(pdfFile string) (int, error) {
+	//gs -q -dNODISPLAY -c "(Code Complete - Steve McConnel.pdf) (r) file runpdfbegin pdfpagecount = quit"
+	cmdArg := fmt.Sprintf("(%v) (r) file runpdfbegin pdfpagecount = quit", pdfFile)
+	args := []string{"-q", "-dNODISPLAY", "-c", cmdArg}
+
+	gs := exec.Command("gs", args...)
+	output, err := executil.RunWithVerboseError(gs)
+	if err != nil {
+		return 0, err
+	}
+
+	numberOfPages, err := strconv.ParseInt(strings.TrimSpace(output), 10, 0)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get number of pages of '%v': %v", pdfFile, err)
+	}
+	return int(numberOfPages), nil
+}
+
+// PageSize returns the width and height, in points, of the MediaBox of the
+// given page (starting at 1) of pdfFile.
+func PageSize(pdfFile string, page int) (float64, float64, error) {
+	//gs -q -dNODISPLAY -c "(input.pdf) (r) file runpdfbegin 1 pdfgetpage /MediaBox pget pop == quit"
+	cmdArg := fmt.Sprintf("(%v) (r) file runpdfbegin %d pdfgetpage /MediaBox pget pop == quit", pdfFile, page)
+	args := []string{"-q", "-dNODISPLAY", "-c", cmdArg}
+
+	gs := exec.Command("gs", args...)
+	output, err := executil.RunWithVerboseError(gs)
+	if err != nil {
+		return 0, 0, err
+	}
+
+	// e.g. [0 0 595.276 841.89]
+	fields := strings.Fields(strings.Trim(strings.TrimSpace(output), "[]"))
+	if len(fields) != 4 {
+		return 0, 0, fmt.Errorf("failed to get the page size of '%v': unexpected MediaBox '%v'", pdfFile, strings.TrimSpace(output))
+	}
+	var box [4]float64
+	for i, field := range fields {
+		box[i], err = strconv.ParseFloat(field, 64)
+		if err != nil {
+			return 0, 0, fmt.Errorf("failed to get the page size of '%v': %v", pdfFile, err)
+		}
+	}
+	return math.Abs(box[2] - box[0]), math.Abs(box[3] - box[1]), nil
+}
+
+// ExtractPages writes the pages from initialPage to lastPage (inclusive) of inputFile to outputFile.
+func ExtractPages(inputFile string, initialPage, lastPage int, outputFile string) error {
+	//gs -sDEVICE=pdfwrite -dNOPAUSE -dBATCH -dSAFER -dFirstPage=1 -dLastPage=4 -sOutputFile=outputT4.pdf T4.pdf
+	initialPageArg := fmt.Sprintf("-dFirstPage=%d", initialPage)
+	lastPageArg := fmt.Sprintf("-dLastPage=%d", lastPage)
+	outputFileArg := fmt.Sprintf("-sOutputFile=%v", outputFile)
+	args := []string{"-sDEVICE=pdfwrite", "-dNOPAUSE", "-dBATCH", "-dSAFER", initialPageArg, lastPageArg, outputFileArg, inputFile}
+
+	gs := exec.Command("gs", args...)
+	_, err := executil.RunWithVerboseError(gs)
+	return err
+}
+
+// Burst writes every page of inputFile to its own pdf file inside dir and
+// returns the created files in page order.
+func Burst(inputFile string, dir string) ([]string, error) {
+	numberOfPages, err := NumberOfPages(inputFile)
+	if err != nil {
+		return nil, err
+	}
+
+	base := filepath.Base(inputFile)
+	base = base[:len(base)-len(filepath.Ext(base))]
+
+	var pages []string
+	for i := 1; i <= numberOfPages; i++ {
+		outputFile := filepath.Join(dir, fmt.Sprintf("%v_page%04d.pdf", base, i))
+		if err := ExtractPages(inputFile, i, i, outputFile); err != nil {
+			return nil, err
+		}
+		pages = append(pages, outputFile)
+	}
+	return pages, nil
+}
+
+// Merge writes the pages of inputFiles, in order, to outputFile.
+func Merge(outputFile string, inputFiles []string) error {
+	args := []string{"-o", outputFile, "-sDEVICE=pdfwrite", "-dPDFSETTINGS=/prepress"}
+	args = append(args, inputFiles...)
+
+	gs := exec.Command("gs", args...)
+	_, err := executil.RunWithVerboseError(gs)
+	return err
+}
+
+// BlankPage writes a pdf with a single blank page of width by height points to outputFile.
+func BlankPage(outputFile string, width, height int) error {
+	// gs -o blank.pdf -sDEVICE=pdfwrite -dDEVICEWIDTHPOINTS=595 -dDEVICEHEIGHTPOINTS=842 -c showpage
+	widthArg := fmt.Sprintf("-dDEVICEWIDTHPOINTS=%d", width)
+	heightArg := fmt.Sprintf("-dDEVICEHEIGHTPOINTS=%d", height)
+	args := []string{"-o", outputFile, "-sDEVICE=pdfwrite", "-dFIXEDMEDIA", widthArg, heightArg, "-c", "showpage"}
+
+	gs := exec.Command("gs", args...)
+	_, err := executil.RunWithVerboseError(gs)
+	return err
+}
+
+// Reorder writes the pages of inputFile to outputFile in the given order.
+// Pages start at 1 and may repeat; 0 inserts a blank page of the size of the
+// first page.
+func Reorder(inputFile string, outputFile string, order []int) error {
+	tempDir, err := ioutil.TempDir("", "goreorder")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	pages, err := Burst(inputFile, tempDir)
+	if err != nil {
+		return err
+	}
+
+	var blankPage string
+	var orderedFiles []string
+	for _, page := range order {
+		switch {
+		case page == 0:
+			if blankPage == "" {
+				if len(pages) == 0 {
+					return fmt.Errorf("'%v' has no pages", inputFile)
+				}
+				width, height, err := PageSize(pages[0], 1)
+				if err != nil {
+					return err
+				}
+				blankPage = filepath.Join(tempDir, "blank.pdf")
+				if err := BlankPage(blankPage, int(math.Round(width)), int(math.Round(height))); err != nil {
+					return err
+				}
+			}
+			orderedFiles = append(orderedFiles, blankPage)
+		case page > len(pages):
+			return fmt.Errorf("'%v' has no page %v", inputFile, page)
+		default:
+			orderedFiles = append(orderedFiles, pages[page-1])
+		}
+	}
+
+	return Merge(outputFile, orderedFiles)
+}
@@ -0,0 +1,347 @@
+package pdfutil
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/mateusbraga/tools/executil"
+)
+
+// StampOptions describes a text or an image stamped over the pages of a pdf.
+type StampOptions struct {
+	Text     string  // may contain {page}, {pages} and {bates}
+	Image    string  // image stamped instead of Text
+	Position string  // one of stampPositions
+	FontSize float64 // in points
+	Scale    float64 // scale of Image
+	Color    string  // of Text, as #rrggbb
+	Opacity  float64 // from 0 (invisible) to 1
+	Rotation float64 // in degrees, counterclockwise
+	Margin   float64 // distance in points from the page edges
+	Pages    string  // page selection, see ParsePages
+
+	BatesPrefix string
+	BatesStart  int // bates number of the first page
+	BatesDigits int
+}
+
+// stampPositions maps the stamp positions to the horizontal and vertical
+// alignment of the stamp: 0 for left/bottom, 1 for center and 2 for right/top.
+var stampPositions = map[string][2]int{
+	"top-left":     {0, 2},
+	"top":          {1, 2},
+	"top-right":    {2, 2},
+	"left":         {0, 1},
+	"center":       {1, 1},
+	"right":        {2, 1},
+	"bottom-left":  {0, 0},
+	"bottom":       {1, 0},
+	"bottom-right": {2, 0},
+}
+
+var (
+	colorRegexp       = regexp.MustCompile(`^#([0-9a-fA-F]{6})$`)
+	boundingBoxRegexp = regexp.MustCompile(`^%%BoundingBox:\s*(-?\d+)\s+(-?\d+)\s+(-?\d+)\s+(-?\d+)`)
+	textPartRegexp    = regexp.MustCompile(`\{(page|pages|bates)\}`)
+)
+
+// StampFlags registers on the default flag set the flags to stamp pdfs.
+func StampFlags() *StampOptions {
+	opts := &StampOptions{}
+	flag.StringVar(&opts.Text, "stamp-text", "", "Stamp this text. Placeholders: {page}, {pages}, {bates}")
+	flag.StringVar(&opts.Image, "stamp-image", "", "Stamp this image")
+	flag.StringVar(&opts.Position, "stamp-position", "bottom-right", "Stamp position: top-left, top, top-right, left, center, right, bottom-left, bottom or bottom-right")
+	flag.Float64Var(&opts.FontSize, "stamp-font-size", 12, "Font size of the stamp text, in points")
+	flag.Float64Var(&opts.Scale, "stamp-scale", 1, "Scale of the stamp image")
+	flag.StringVar(&opts.Color, "stamp-color", "#000000", "Color of the stamp text")
+	flag.Float64Var(&opts.Opacity, "stamp-opacity", 1, "Opacity of the stamp, from 0 to 1")
+	flag.Float64Var(&opts.Rotation, "stamp-rotation", 0, "Rotation of the stamp in degrees, counterclockwise")
+	flag.Float64Var(&opts.Margin, "stamp-margin", 24, "Distance of the stamp from the page edges, in points")
+	flag.StringVar(&opts.Pages, "stamp-pages", "", "Pages to stamp, e.g. 1-3,7,10- (default: all pages)")
+	flag.StringVar(&opts.BatesPrefix, "bates-prefix", "", "Prefix of the bates numbers. Stamps '{bates}' if no text is given")
+	flag.IntVar(&opts.BatesStart, "bates-start", 1, "Bates number of the first page")
+	flag.IntVar(&opts.BatesDigits, "bates-digits", 6, "Bates numbers are zero padded to this number of digits")
+	return opts
+}
+
+// Enabled reports whether there is something to stamp.
+func (o StampOptions) Enabled() bool {
+	return o.Text != "" || o.Image != "" || o.BatesPrefix != ""
+}
+
+// Check returns an error if the options can not be used to stamp a pdf.
+func (o StampOptions) Check() error {
+	if o.Text != "" && o.Image != "" {
+		return errors.New("stamp either a text or an image")
+	}
+	if _, ok := stampPositions[o.Position]; !ok {
+		return fmt.Errorf("unknown stamp position '%v'", o.Position)
+	}
+	if !colorRegexp.MatchString(o.Color) {
+		return fmt.Errorf("invalid stamp color '%v', use #rrggbb", o.Color)
+	}
+	if o.Opacity < 0 || o.Opacity > 1 {
+		return fmt.Errorf("invalid stamp opacity %v, use a value from 0 to 1", o.Opacity)
+	}
+	if o.FontSize <= 0 || o.Scale <= 0 {
+		return errors.New("stamp font size and scale must be positive")
+	}
+	return nil
+}
+
+// Stamp writes inputFile to outputFile with the stamp described by opts over
+// the selected pages. It returns the number of pages of the document, so that
+// bates numbers can continue on the next document of a batch.
+func Stamp(inputFile string, outputFile string, opts StampOptions) (int, error) {
+	if err := opts.Check(); err != nil {
+		return 0, err
+	}
+	if opts.Text == "" && opts.Image == "" {
+		opts.Text = "{bates}"
+	}
+
+	numberOfPages, err := NumberOfPages(inputFile)
+	if err != nil {
+		return 0, err
+	}
+	pages, err := ParsePages(opts.Pages, numberOfPages)
+	if err != nil {
+		return 0, err
+	}
+
+	tempDir, err := ioutil.TempDir("", "gostamp")
+	if err != nil {
+		return 0, err
+	}
+	defer os.RemoveAll(tempDir)
+
+	args := []string{"-sDEVICE=pdfwrite", "-dNOPAUSE", "-dBATCH", "-dQUIET"}
+
+	var program string
+	if opts.Image != "" {
+		epsFile := filepath.Join(tempDir, "stamp.eps")
+		convert := exec.Command("convert", opts.Image, "eps3:"+epsFile)
+		if _, err := executil.RunWithVerboseError(convert); err != nil {
+			return 0, err
+		}
+		bbox, err := epsBoundingBox(epsFile)
+		if err != nil {
+			return 0, err
+		}
+		program = stampImageProgram(opts, numberOfPages, pages, epsFile, bbox)
+		args = append(args, "--permit-file-read="+epsFile)
+	} else {
+		program = stampTextProgram(opts, numberOfPages, pages)
+	}
+
+	programFile := filepath.Join(tempDir, "stamp.ps")
+	if err := ioutil.WriteFile(programFile, []byte(program), 0600); err != nil {
+		return 0, err
+	}
+
+	// gs -sDEVICE=pdfwrite -dNOPAUSE -dBATCH -sOutputFile=output.pdf stamp.ps input.pdf
+	args = append(args, fmt.Sprintf("-sOutputFile=%v", outputFile), programFile, inputFile)
+
+	gs := exec.Command("gs", args...)
+	_, err = executil.RunWithVerboseError(gs)
+	return numberOfPages, err
+}
+
+// StampInPlace replaces pdfFile with its stamped version.
+func StampInPlace(pdfFile string, opts StampOptions) (int, error) {
+	tempFile := pdfFile + ".stamped.pdf"
+	numberOfPages, err := Stamp(pdfFile, tempFile, opts)
+	if err != nil {
+		os.Remove(tempFile)
+		return 0, err
+	}
+	return numberOfPages, os.Rename(tempFile, pdfFile)
+}
+
+// stampProgramHeader defines the procedures shared by the text and image
+// stamps. StampBody draws the stamp with its lower left corner at the origin
+// and needs StampWidth and StampHeight to be defined.
+const stampProgramHeader = `%%!
+/StampSelected [ %v ] def
+/StampNumberOfPages %d def
+/StampPosition [ %d %d ] def
+/StampMargin %v def
+/StampRotation %v def
+/StampOpacity %v def
+/BatesPrefix (%v) def
+/BatesStart %d def
+/BatesDigits %d def
+
+/concatstrings { %% s1 s2 -> s1s2
+  exch dup length 2 index length add string dup dup 4 2 roll copy length 4 -1 roll putinterval
+} bind def
+
+/zeropad { %% n digits -> s
+  2 dict begin
+  /digits exch def
+  20 string cvs /s exch def
+  digits s length gt {
+    digits string
+    0 1 digits 1 sub { 1 index exch 48 put } for
+    dup digits s length sub s putinterval
+  } { s } ifelse
+  end
+} bind def
+
+/StampPage { %% page -> -
+  /page exch def
+  gsave
+  initgraphics
+  /.setfillconstantalpha where {
+    pop StampOpacity .setfillconstantalpha
+  } {
+    /.setopacityalpha where { pop StampOpacity .setopacityalpha } if
+  } ifelse
+  StampSetup
+  currentpagedevice /PageSize get aload pop /pageHeight exch def /pageWidth exch def
+  %% anchor point of the stamp in the page
+  [ StampMargin pageWidth 2 div pageWidth StampMargin sub ] StampPosition 0 get get
+  [ StampMargin pageHeight 2 div pageHeight StampMargin sub ] StampPosition 1 get get
+  translate
+  StampRotation rotate
+  %% align the stamp to the anchor point
+  StampWidth StampPosition 0 get mul 2 div neg
+  StampHeight StampPosition 1 get mul 2 div neg
+  translate
+  StampBody
+  grestore
+} bind def
+
+<< /EndPage {
+  exch
+  1 index 0 eq {
+    dup 1 add dup StampSelected exch get { StampPage } { pop } ifelse
+  } if
+  pop
+  2 ne
+} bind >> setpagedevice
+`
+
+func stampHeader(opts StampOptions, numberOfPages int, pages []int) string {
+	selected := make([]string, numberOfPages+1)
+	for i := range selected {
+		selected[i] = "false"
+	}
+	for _, page := range pages {
+		selected[page] = "true"
+	}
+
+	h := stampPositions[opts.Position]
+	return fmt.Sprintf(stampProgramHeader,
+		strings.Join(selected, " "),
+		numberOfPages,
+		h[0], h[1],
+		opts.Margin,
+		opts.Rotation,
+		opts.Opacity,
+		postScriptEscape(opts.BatesPrefix),
+		opts.BatesStart,
+		opts.BatesDigits,
+	)
+}
+
+// stampTextProgram returns the program that stamps opts.Text.
+func stampTextProgram(opts StampOptions, numberOfPages int, pages []int) string {
+	rgb, _ := strconv.ParseUint(opts.Color[1:], 16, 32)
+	red, green, blue := float64(rgb>>16&0xff)/255, float64(rgb>>8&0xff)/255, float64(rgb&0xff)/255
+
+	// StampText leaves the text of the current page on the stack
+	var text strings.Builder
+	text.WriteString("/StampText {\n  ()\n")
+	last := 0
+	for _, match := range textPartRegexp.FindAllStringSubmatchIndex(opts.Text, -1) {
+		if match[0] > last {
+			fmt.Fprintf(&text, "  (%v) concatstrings\n", postScriptEscape(opts.Text[last:match[0]]))
+		}
+		switch opts.Text[match[2]:match[3]] {
+		case "page":
+			text.WriteString("  page 20 string cvs concatstrings\n")
+		case "pages":
+			text.WriteString("  StampNumberOfPages 20 string cvs concatstrings\n")
+		case "bates":
+			text.WriteString("  BatesPrefix concatstrings BatesStart page add 1 sub BatesDigits zeropad concatstrings\n")
+		}
+		last = match[1]
+	}
+	if last < len(opts.Text) {
+		fmt.Fprintf(&text, "  (%v) concatstrings\n", postScriptEscape(opts.Text[last:]))
+	}
+	text.WriteString("} bind def\n")
+
+	return stampHeader(opts, numberOfPages, pages) + text.String() + fmt.Sprintf(`
+/StampSetup {
+  /Helvetica findfont %v scalefont setfont
+  %v %v %v setrgbcolor
+  /StampString StampText def
+  /StampWidth StampString stringwidth pop def
+  /StampHeight %v 0.7 mul def
+} bind def
+
+/StampBody {
+  0 0 moveto StampString show
+} bind def
+`, opts.FontSize, red, green, blue, opts.FontSize)
+}
+
+// stampImageProgram returns the program that stamps the eps version of opts.Image.
+func stampImageProgram(opts StampOptions, numberOfPages int, pages []int, epsFile string, bbox [4]int) string {
+	width := float64(bbox[2]-bbox[0]) * opts.Scale
+	height := float64(bbox[3]-bbox[1]) * opts.Scale
+
+	return stampHeader(opts, numberOfPages, pages) + fmt.Sprintf(`
+/StampSetup {
+  /StampWidth %v def
+  /StampHeight %v def
+} bind def
+
+/StampBody {
+  save
+  /showpage {} def
+  %v %v scale
+  %d neg %d neg translate
+  (%v) run
+  restore
+} bind def
+`, width, height, opts.Scale, opts.Scale, bbox[0], bbox[1], postScriptEscape(epsFile))
+}
+
+// epsBoundingBox returns the llx, lly, urx and ury of the %%BoundingBox of epsFile.
+func epsBoundingBox(epsFile string) ([4]int, error) {
+	var bbox [4]int
+
+	f, err := os.Open(epsFile)
+	if err != nil {
+		return bbox, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		match := boundingBoxRegexp.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		for i := range bbox {
+			bbox[i], _ = strconv.Atoi(match[i+1])
+		}
+		return bbox, nil
+	}
+	if err := scanner.Err(); err != nil {
+		return bbox, err
+	}
+	return bbox, fmt.Errorf("no bounding box found in '%v'", epsFile)
+}